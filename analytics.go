@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// logZoneAnalytics logs the zone's request count and bandwidth over the last hour,
+// giving context on how much traffic a record change affects. Errors are logged
+// rather than returned since the update itself has succeeded.
+func logZoneAnalytics(ctx context.Context, api *cloudflare.API, zoneID string) {
+	until := time.Now().UTC()
+	since := until.Add(-time.Hour)
+	data, err := api.ZoneAnalyticsDashboard(ctx, zoneID, cloudflare.ZoneAnalyticsOptions{
+		Since: &since,
+		Until: &until,
+	})
+	if err != nil {
+		slog.Warn("Unable to read zone analytics", "error", err)
+		return
+	}
+
+	name, _ := currentZone(ctx)
+	slog.Info("Zone traffic over the last hour",
+		"zone", name,
+		"since", since,
+		"requests", data.Totals.Requests.All,
+		"requests_cached", data.Totals.Requests.Cached,
+		"bandwidth_bytes", data.Totals.Bandwidth.All,
+		"bandwidth_cached_bytes", data.Totals.Bandwidth.Cached,
+	)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// auditEntry is a single line of the --cloudflare-api-audit trail
+type auditEntry struct {
+	Time         time.Time `json:"time"`
+	Method       string    `json:"method"`
+	URL          string    `json:"url"`
+	RequestBody  string    `json:"request_body,omitempty"`
+	Status       int       `json:"status,omitempty"`
+	ResponseBody string    `json:"response_body,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	DurationMS   int64     `json:"duration_ms"`
+}
+
+// auditTransport appends every API call to --cloudflare-api-audit-file, or to
+// stderr when no file is set
+type auditTransport struct {
+	path string
+	next http.RoundTripper
+}
+
+// auditMu serializes writes to the audit trail, which may be shared by several API clients
+var auditMu sync.Mutex
+
+func (t *auditTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	entry := auditEntry{
+		Time:   time.Now().UTC(),
+		Method: req.Method,
+		URL:    req.URL.String(),
+	}
+	// Read a copy of the body so the request itself is left untouched
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			data, _ := io.ReadAll(body)
+			body.Close()
+			entry.RequestBody = redactBody(data)
+		}
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	entry.DurationMS = time.Since(entry.Time).Milliseconds()
+	if err != nil {
+		entry.Error = err.Error()
+	} else {
+		entry.Status = resp.StatusCode
+		body, readErr := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		entry.ResponseBody = redactBody(body)
+		// Token endpoints return new token values in plain result fields
+		if strings.Contains(req.URL.Path, "/tokens") {
+			entry.ResponseBody = redacted
+		}
+		if readErr != nil {
+			entry.Error = readErr.Error()
+		}
+	}
+
+	// A broken audit trail must not block DNS updates
+	if auditErr := t.write(entry); auditErr != nil {
+		slog.Warn("Unable to write API audit record", "error", auditErr)
+	}
+	return resp, err
+}
+
+func (t *auditTransport) write(entry auditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("error encoding audit record: %w", err)
+	}
+	line = append(line, '\n')
+
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	if t.path == "" {
+		_, err := os.Stderr.Write(line)
+		return err
+	}
+	f, err := os.OpenFile(t.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("error opening audit file %s: %w", t.path, err)
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing audit file %s: %w", t.path, err)
+	}
+	return f.Close()
+}
+
+// secretFieldMarkers identify JSON fields holding credentials, matched against
+// the lowercased field name
+var secretFieldMarkers = []string{"token", "secret", "password", "api_key"}
+
+// redactBody returns a JSON body with the values of secret fields replaced.
+// Bodies that are not JSON are returned unchanged.
+func redactBody(body []byte) string {
+	var value interface{}
+	if len(body) == 0 || json.Unmarshal(body, &value) != nil {
+		return string(body)
+	}
+	redacted, err := json.Marshal(redactSecretFields(value))
+	if err != nil {
+		return string(body)
+	}
+	return string(redacted)
+}
+
+func redactSecretFields(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if isSecretField(key) {
+				v[key] = redacted
+				continue
+			}
+			v[key] = redactSecretFields(field)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactSecretFields(item)
+		}
+	}
+	return value
+}
+
+func isSecretField(key string) bool {
+	key = strings.ToLower(key)
+	for _, marker := range secretFieldMarkers {
+		if strings.Contains(key, marker) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/spf13/viper"
+)
+
+// batchPatch is one record update in a batch request. The ID of
+// UpdateDNSRecordParams is not serialized, so it is added here.
+type batchPatch struct {
+	ID string `json:"id"`
+	cloudflare.UpdateDNSRecordParams
+}
+
+// batchUpdateDNSRecords sends patches to the (beta) batch DNS records endpoint in a
+// single call. Cloudflare applies a batch atomically: either every patch succeeds or none do.
+func batchUpdateDNSRecords(ctx context.Context, api *cloudflare.API, zone *cloudflare.ResourceContainer, patches []cloudflare.UpdateDNSRecordParams) error {
+	body := struct {
+		Patches []batchPatch `json:"patches"`
+	}{}
+	for _, patch := range patches {
+		body.Patches = append(body.Patches, batchPatch{ID: patch.ID, UpdateDNSRecordParams: patch})
+	}
+
+	_, err := api.Raw(ctx, http.MethodPost, "/zones/"+zone.Identifier+"/dns_records/batch", body, nil)
+	return err
+}
+
+// updateRecordsBatched points records at ip in batches of --cloudflare-update-batch-size,
+// falling back to one UpdateDNSRecord call per record when the zone's plan has no
+// batch API
+func updateRecordsBatched(ctx context.Context, api *cloudflare.API, zone *cloudflare.ResourceContainer, records []cloudflare.DNSRecord, ip string) error {
+	var patches []cloudflare.UpdateDNSRecordParams
+	oldContent := make(map[string]string, len(records))
+	var errs []error
+	for _, record := range records {
+		params, err := recordUpdateParams(ctx, record, ip)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if params == nil {
+			if !viper.GetBool("dry-run") {
+				publishRecordEvent(ctx, "unchanged", record.Name, record.Content, ip)
+			}
+			continue
+		}
+		if viper.GetBool("dry-run") {
+			logDryRun("update", record.Type, record.Name, record.ID, record.Content, params.Content, params.TTL, params.Proxied)
+			continue
+		}
+		patches = append(patches, *params)
+		oldContent[record.ID] = record.Content
+	}
+
+	writeAPI, err := newWriteAPI(ctx, api)
+	if err != nil {
+		return fmt.Errorf("error initializing Cloudflare API: %w", err)
+	}
+
+	size := viper.GetInt("cloudflare-update-batch-size")
+	for len(patches) > 0 {
+		batch := patches[:min(size, len(patches))]
+		patches = patches[len(batch):]
+
+		err := batchUpdateDNSRecords(ctx, writeAPI, zone, batch)
+		var notFound *cloudflare.NotFoundError
+		if errors.As(err, &notFound) {
+			slog.Warn("Batch DNS API is not available, updating records one at a time", "error", err)
+			for _, params := range append(batch, patches...) {
+				if _, err := writeAPI.UpdateDNSRecord(ctx, zone, params); err != nil {
+					if err := ignoreAPIError(err); err != nil {
+						errs = append(errs, fmt.Errorf("error updating DNS record %s: %w", params.Name, err))
+					}
+					continue
+				}
+				recordUpdated(ctx, api, zone, params.Name, oldContent[params.ID], ip, params.Proxied != nil && *params.Proxied)
+			}
+			break
+		}
+		if err != nil {
+			if err := ignoreAPIError(err); err != nil {
+				errs = append(errs, fmt.Errorf("error updating batch of %d DNS records: %w", len(batch), err))
+			}
+			continue
+		}
+
+		slog.Debug("Updated batch of DNS records", "count", len(batch))
+		for _, params := range batch {
+			recordUpdated(ctx, api, zone, params.Name, oldContent[params.ID], ip, params.Proxied != nil && *params.Proxied)
+		}
+	}
+	return errors.Join(errs...)
+}
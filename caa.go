@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/spf13/viper"
+)
+
+// caaTags are the CAA property tags defined by RFC 8659
+var caaTags = map[string]bool{
+	"issue":     true,
+	"issuewild": true,
+	"iodef":     true,
+}
+
+// caaData builds the Cloudflare data map of a CAA record
+func caaData(flags int, tag, value string) map[string]interface{} {
+	return map[string]interface{}{
+		"flags": flags,
+		"tag":   tag,
+		"value": value,
+	}
+}
+
+// updateCAARecord sets the CAA record of name with the configured --caa-tag
+// to --caa-value and --caa-flags
+func updateCAARecord(ctx context.Context, api *cloudflare.API, zone *cloudflare.ResourceContainer, name string) error {
+	tag := viper.GetString("caa-tag")
+	value := viper.GetString("caa-value")
+	flags := viper.GetInt("caa-flags")
+
+	records, _, err := listDNSRecords(ctx, api, zone, cloudflare.ListDNSRecordsParams{
+		Name: name,
+		Type: "CAA",
+	})
+	if err != nil {
+		return fmt.Errorf("error fetching DNS records: %w", err)
+	}
+
+	for _, record := range records {
+		data, _ := record.Data.(map[string]interface{})
+		if data["tag"] != tag {
+			continue
+		}
+
+		// Cloudflare returns the flags as a JSON number
+		if data["value"] == value && fmt.Sprint(data["flags"]) == fmt.Sprint(flags) {
+			slog.Info("CAA record already up-to-date", "tag", tag, "record", name, "value", value)
+			return nil
+		}
+
+		if viper.GetBool("dry-run") {
+			logDryRun("update", record.Type, name, record.ID, fmt.Sprint(data["value"]), value, record.TTL, record.Proxied)
+			return nil
+		}
+
+		writeAPI, err := newWriteAPI(ctx, api)
+		if err != nil {
+			return fmt.Errorf("error initializing Cloudflare API: %w", err)
+		}
+		_, err = writeAPI.UpdateDNSRecord(ctx, zone, cloudflare.UpdateDNSRecordParams{
+			Type: record.Type,
+			Name: record.Name,
+			Data: caaData(flags, tag, value),
+			TTL:  record.TTL,
+			ID:   record.ID,
+			Tags: record.Tags,
+		})
+		if err != nil {
+			if err := ignoreAPIError(err); err != nil {
+				return fmt.Errorf("error updating CAA record: %w", err)
+			}
+			return nil
+		}
+
+		slog.Info("Updated CAA record", "tag", tag, "record", name, "value", value)
+		changedRecords.Add(1)
+		return nil
+	}
+
+	return fmt.Errorf("no CAA %s records found for %s", tag, name)
+}
+
+// validateCAA checks the --caa-* flags used with --record-type=CAA
+func validateCAA(tag, value string, flags int) error {
+	if !caaTags[tag] {
+		return fmt.Errorf("--caa-tag %q must be issue, issuewild or iodef", tag)
+	}
+	if value == "" {
+		return fmt.Errorf("--caa-value is required")
+	}
+	if flags < 0 || flags > 255 {
+		return fmt.Errorf("--caa-flags %d must be between 0 and 255", flags)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// cdnOriginHeaders are the response headers searched for the origin IP
+var cdnOriginHeaders = []string{"CF-Cache-Status", "X-Forwarded-For"}
+
+// verifyCDNOrigin requests https://<name>/ through Cloudflare's edge and warns
+// unless the response reports ip as the origin, so a proxied record whose edge
+// configuration still points at the old IP is noticed. Errors are logged rather
+// than returned since the update itself has succeeded.
+func verifyCDNOrigin(ctx context.Context, name, ip string) {
+	if err := checkCDNOrigin(ctx, name, ip); err != nil {
+		slog.Warn("Unable to confirm Cloudflare routes traffic to the new origin IP", "record", name, "ip", ip, "error", err)
+		return
+	}
+	slog.Info("Cloudflare routes traffic to the new origin IP", "record", name, "ip", ip)
+}
+
+func checkCDNOrigin(ctx context.Context, name, ip string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+name+"/", nil)
+	if err != nil {
+		return err
+	}
+	req.Host = name
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.Header.Get("CF-Ray") == "" {
+		return fmt.Errorf("response was not served by Cloudflare (no CF-Ray header)")
+	}
+	for _, header := range cdnOriginHeaders {
+		if strings.Contains(resp.Header.Get(header), ip) {
+			return nil
+		}
+	}
+	return fmt.Errorf("neither the %s header contains the IP", strings.Join(cdnOriginHeaders, " nor the "))
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/spf13/viper"
+)
+
+// isManagedRecord reports whether record was written by this client: its comment
+// starts with --record-comment-prefix or, when no prefix is set, holds the
+// metadata of --cloudflare-comment-encoding
+func isManagedRecord(record cloudflare.DNSRecord) bool {
+	if prefix := viper.GetString("record-comment-prefix"); prefix != "" {
+		return strings.HasPrefix(record.Comment, prefix)
+	}
+	_, err := decodeRecordComment(record.Comment)
+	return err == nil
+}
+
+// ownsRecord reports whether record belongs to this instance rather than to
+// another host sharing the zone: it is one of names or carries this host's
+// hostname tag. Split-horizon records never do, as the internal one holds a LAN
+// address by design.
+func ownsRecord(record cloudflare.DNSRecord, names []string) bool {
+	if slices.Contains(record.Tags, splitHorizonInternalTag) || slices.Contains(record.Tags, splitHorizonExternalTag) {
+		return false
+	}
+	if slices.Contains(names, record.Name) {
+		return true
+	}
+	hostname, err := os.Hostname()
+	return err == nil && slices.Contains(record.Tags, "hostname:"+hostname)
+}
+
+// cleanupRecords deletes the managed A and AAAA records of this instance that
+// don't point at the current public IP, after confirmation read from in unless
+// --yes is set
+func cleanupRecords(ctx context.Context, in io.Reader, w io.Writer) error {
+	api, err := newCloudflareAPI(readToken(ctx))
+	if err != nil {
+		return fmt.Errorf("error initializing Cloudflare API: %w", err)
+	}
+	zoneName, names := currentZone(ctx)
+	zoneID, err := lookupZoneID(api, zoneName)
+	if err != nil {
+		return fmt.Errorf("error fetching Zone ID for %s: %w", zoneName, err)
+	}
+	zone := cloudflare.ZoneIdentifier(zoneID)
+
+	// The API only filters on exact comments, so the prefix is matched here
+	records, _, err := listDNSRecords(ctx, api, zone, cloudflare.ListDNSRecordsParams{})
+	if err != nil {
+		return fmt.Errorf("error fetching DNS records: %w", err)
+	}
+
+	current := map[string]string{}
+	var stale []cloudflare.DNSRecord
+	for _, record := range records {
+		if (record.Type != "A" && record.Type != "AAAA") || !isManagedRecord(record) || !ownsRecord(record, names) {
+			continue
+		}
+
+		ip, ok := current[record.Type]
+		if !ok {
+			ip, err = currentIP(ctx, record.Type)
+			if err != nil {
+				// Without the current address nothing of this type is known to be stale
+				slog.Warn("Unable to detect the current IP, keeping records", "type", record.Type, "error", err)
+			}
+			current[record.Type] = ip
+		}
+		if ip != "" && record.Content != ip {
+			stale = append(stale, record)
+		}
+	}
+
+	if len(stale) == 0 {
+		fmt.Fprintln(w, "No stale managed records found")
+		return nil
+	}
+	for _, record := range stale {
+		fmt.Fprintf(w, "%s\t%s\t%s\t(current IP %s)\n", record.Type, record.Name, record.Content, current[record.Type])
+	}
+	if viper.GetBool("dry-run") {
+		fmt.Fprintf(w, "Dry run: would delete %d records\n", len(stale))
+		return nil
+	}
+	if !viper.GetBool("yes") && !confirm(in, w, fmt.Sprintf("Delete %d records?", len(stale))) {
+		fmt.Fprintln(w, "Aborted")
+		return nil
+	}
+
+	writeAPI, err := newWriteAPI(ctx, api)
+	if err != nil {
+		return fmt.Errorf("error initializing Cloudflare API: %w", err)
+	}
+	for _, record := range stale {
+		if err := writeAPI.DeleteDNSRecord(ctx, zone, record.ID); err != nil {
+			return fmt.Errorf("error deleting %s record %s: %w", record.Type, record.Name, err)
+		}
+		slog.Info("Deleted stale DNS record", "type", record.Type, "record", record.Name, "ip", record.Content)
+	}
+	fmt.Fprintf(w, "Deleted %d records\n", len(stale))
+	return nil
+}
+
+// currentIP detects the public address that records of recordType should hold
+func currentIP(ctx context.Context, recordType string) (string, error) {
+	if recordType == "AAAA" {
+		return getPublicIPv6(ctx)
+	}
+	return detectIP(ctx, primaryIPSource())
+}
+
+// confirm asks question on w and reports whether the answer read from in is yes
+func confirm(in io.Reader, w io.Writer, question string) bool {
+	fmt.Fprintf(w, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
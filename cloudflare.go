@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/spf13/viper"
+	"golang.org/x/time/rate"
+)
+
+// defaultCloudflareAPIURL is the public Cloudflare v4 API endpoint
+const defaultCloudflareAPIURL = "https://api.cloudflare.com/client/v4"
+
+// cloudflareTransport carries Cloudflare API requests beneath the logging, rate
+// limiting and retry layers. Tests point it at a mock API server.
+var cloudflareTransport http.RoundTripper = http.DefaultTransport
+
+// newCloudflareAPI creates a Cloudflare API client for token using the configured endpoint
+func newCloudflareAPI(token string) (*cloudflare.API, error) {
+	baseURL := viper.GetString("cloudflare-api-url")
+	if err := validateHTTPSURL(baseURL); err != nil {
+		return nil, fmt.Errorf("invalid --cloudflare-api-url: %w", err)
+	}
+
+	header, err := parseHeaders(viper.GetStringSlice("cloudflare-header"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid --cloudflare-header: %w", err)
+	}
+
+	retryCodes, err := parseStatusCodes(viper.GetStringSlice("cloudflare-retry-on-codes"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid --cloudflare-retry-on-codes: %w", err)
+	}
+
+	transport := cloudflareTransport
+	if logPath := viper.GetString("cloudflare-request-log-file"); logPath != "" {
+		// --cloudflare-header values are often gateway credentials
+		var secretHeaders []string
+		for key := range header {
+			secretHeaders = append(secretHeaders, key)
+		}
+		transport = &requestLogTransport{path: logPath, secretHeaders: secretHeaders, next: transport}
+	}
+	if viper.GetBool("cloudflare-api-audit") {
+		transport = &auditTransport{path: viper.GetString("cloudflare-api-audit-file"), next: transport}
+	}
+	if margin := viper.GetFloat64("rate-limit-safety-margin"); margin > 0 {
+		transport = &rateLimitTransport{margin: margin, state: &cloudflareRateLimit, next: transport}
+	}
+	transport = &throttleTransport{limiter: cloudflareThrottle(), next: transport}
+	transport = &retryTransport{codes: retryCodes, jitter: viper.GetFloat64("cloudflare-api-retry-jitter"), next: transport}
+	if len(header) > 0 {
+		transport = &headerTransport{header: header, next: transport}
+	}
+	// Only the global token is read from Vault; zone, read and write tokens are not
+	if viper.GetBool("vault-auto-reload-on-401") && viper.GetString("secrets-backend") == "vault" &&
+		!viper.GetBool("token-per-zone") && token != "" && token == globalAPIToken() {
+		transport = &tokenReloadTransport{next: transport}
+	}
+
+	return cloudflare.NewWithAPIToken(token,
+		cloudflare.BaseURL(strings.TrimSuffix(baseURL, "/")),
+		cloudflare.HTTPClient(&http.Client{Transport: transport}),
+		// retries are handled by retryTransport and throttling by throttleTransport
+		cloudflare.UsingRetryPolicy(0, 0, 0),
+		cloudflare.UsingRateLimit(float64(rate.Inf)),
+	)
+}
+
+// readToken returns the token used for lookups: the zone's own token in multi-zone
+// mode, --cloudflare-read-token, or the API token
+func readToken(ctx context.Context) string {
+	if target, ok := zoneTargetFrom(ctx); ok && target.APIToken != "" {
+		return target.APIToken
+	}
+	if token := viper.GetString("cloudflare-read-token"); token != "" {
+		return token
+	}
+	return globalAPIToken()
+}
+
+// writeToken returns the token used to change records: the zone's own token in
+// multi-zone mode, --cloudflare-write-token, or the API token
+func writeToken(ctx context.Context) string {
+	if target, ok := zoneTargetFrom(ctx); ok && target.APIToken != "" {
+		return target.APIToken
+	}
+	if token := viper.GetString("cloudflare-write-token"); token != "" {
+		return token
+	}
+	return globalAPIToken()
+}
+
+// newWriteAPI returns the client used to change records. This is api itself
+// unless a separate write token is configured.
+func newWriteAPI(ctx context.Context, api *cloudflare.API) (*cloudflare.API, error) {
+	if token := writeToken(ctx); token != api.APIToken {
+		return newCloudflareAPI(token)
+	}
+	return api, nil
+}
+
+// parseHeaders parses "Key: Value" pairs into an http.Header
+func parseHeaders(pairs []string) (http.Header, error) {
+	header := http.Header{}
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, ":")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%q is not in \"Key: Value\" form", pair)
+		}
+		header.Add(key, strings.TrimSpace(value))
+	}
+	return header, nil
+}
+
+// headerTransport adds a fixed set of headers to every outbound request
+type headerTransport struct {
+	header http.Header
+	next   http.RoundTripper
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for key, values := range t.header {
+		req.Header[key] = values
+	}
+	return t.next.RoundTrip(req)
+}
+
+// resolveResourceContainer returns the container an API operation is routed through.
+// DNS and other zone operations pass zoneID and get a zone container; account
+// operations such as Workers KV or R2 pass an empty zoneID and get an account
+// container for accountID, which is resolved with resolveAccountID when empty.
+func resolveResourceContainer(ctx context.Context, api *cloudflare.API, zoneID, accountID string) (*cloudflare.ResourceContainer, error) {
+	if zoneID != "" {
+		return cloudflare.ZoneIdentifier(zoneID), nil
+	}
+
+	if accountID == "" {
+		var err error
+		if accountID, err = resolveAccountID(ctx, api); err != nil {
+			return nil, err
+		}
+	}
+	return cloudflare.AccountIdentifier(accountID), nil
+}
+
+// resolveAccountID returns --cloudflare-account-id, or looks up the token's account
+// when it is not set. The lookup only succeeds when the token can see exactly one account.
+func resolveAccountID(ctx context.Context, api *cloudflare.API) (string, error) {
+	if accountID := viper.GetString("cloudflare-account-id"); accountID != "" {
+		return accountID, nil
+	}
+
+	accounts, _, err := api.Accounts(ctx, cloudflare.AccountsListParams{})
+	if err != nil {
+		return "", fmt.Errorf("error listing Cloudflare accounts: %w", err)
+	}
+
+	switch len(accounts) {
+	case 0:
+		return "", fmt.Errorf("no Cloudflare accounts are visible to the API token; set --cloudflare-account-id")
+	case 1:
+		slog.Info("Using Cloudflare account", "name", accounts[0].Name, "id", accounts[0].ID)
+		return accounts[0].ID, nil
+	default:
+		for _, account := range accounts {
+			slog.Info("Found Cloudflare account", "name", account.Name, "id", account.ID)
+		}
+		return "", fmt.Errorf("API token can access %d Cloudflare accounts; set --cloudflare-account-id explicitly", len(accounts))
+	}
+}
+
+// cursorPageSize is the page size requested with --cloudflare-pagination-strategy=cursor
+const cursorPageSize = 100
+
+func validatePaginationStrategy(strategy string) error {
+	switch strategy {
+	case "page", "cursor":
+		return nil
+	}
+	return fmt.Errorf("unsupported pagination strategy %q (want page or cursor)", strategy)
+}
+
+// listDNSRecords lists every record matching params, following page numbers or
+// result cursors according to --cloudflare-pagination-strategy
+func listDNSRecords(ctx context.Context, api *cloudflare.API, zone *cloudflare.ResourceContainer, params cloudflare.ListDNSRecordsParams) ([]cloudflare.DNSRecord, *cloudflare.ResultInfo, error) {
+	strategy := viper.GetString("cloudflare-pagination-strategy")
+	if err := validatePaginationStrategy(strategy); err != nil {
+		return nil, nil, err
+	}
+	if strategy == "page" {
+		return api.ListDNSRecords(ctx, zone, params)
+	}
+
+	// Setting PerPage turns off the client's page-number pagination
+	params.PerPage = cursorPageSize
+	var records []cloudflare.DNSRecord
+	for {
+		page, info, err := api.ListDNSRecords(ctx, zone, params)
+		if err != nil {
+			return nil, nil, err
+		}
+		records = append(records, page...)
+		if info.Cursors.After == "" || len(page) == 0 {
+			info.Count = len(records)
+			return records, info, nil
+		}
+		params.Cursors.After = info.Cursors.After
+	}
+}
+
+// boolPtr returns a pointer to a fresh copy of b, so update params never alias
+// the *bool of a record returned by the API
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+// purgeDNSCache asks Cloudflare to purge cached content tagged "dns" so the edge
+// does not keep serving responses from before the update. Purging by tag is only
+// available on Enterprise zones; other plans are skipped with a warning.
+func purgeDNSCache(ctx context.Context, api *cloudflare.API, zoneID string) {
+	zone, err := api.ZoneDetails(ctx, zoneID)
+	if err != nil {
+		slog.Warn("Unable to read zone plan, skipping cache purge", "error", err)
+		return
+	}
+	if zone.Plan.LegacyID != "enterprise" {
+		slog.Warn("Zone plan does not support purging by tag, skipping cache purge", "zone", zone.Name, "plan", zone.Plan.Name)
+		return
+	}
+
+	if _, err := api.PurgeCache(ctx, zoneID, cloudflare.PurgeCacheRequest{Tags: []string{"dns"}}); err != nil {
+		slog.Error("Error purging DNS cache", "zone", zone.Name, "error", err)
+		return
+	}
+	slog.Info("Purged DNS cache", "zone", zone.Name)
+}
+
+// parseErrorCodes parses --cloudflare-ignore-error-codes
+func parseErrorCodes(values []string) (map[int]bool, error) {
+	codes := make(map[int]bool, len(values))
+	for _, value := range values {
+		code, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || code <= 0 {
+			return nil, fmt.Errorf("%q is not a Cloudflare error code", value)
+		}
+		codes[code] = true
+	}
+	return codes, nil
+}
+
+// ignoreAPIError returns nil when err is a Cloudflare API error with a code in
+// --cloudflare-ignore-error-codes, logging it as a warning instead
+func ignoreAPIError(err error) error {
+	var apiErr *cloudflare.Error
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	ignored, parseErr := parseErrorCodes(viper.GetStringSlice("cloudflare-ignore-error-codes"))
+	if parseErr != nil {
+		return err
+	}
+	for _, code := range apiErr.ErrorCodes {
+		if ignored[code] {
+			slog.Warn("Ignoring Cloudflare API error", "code", code, "error", err)
+			return nil
+		}
+	}
+	return err
+}
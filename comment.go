@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// commentTool identifies records written by this client in their metadata comment
+const commentTool = "caddy"
+
+// recordMetadata is stored in the DNS record comment with --cloudflare-comment-encoding=base64-json
+type recordMetadata struct {
+	Tool        string    `json:"tool"`
+	Version     string    `json:"version"`
+	Zone        string    `json:"zone"`
+	Record      string    `json:"record"`
+	LastIP      string    `json:"last_ip"`
+	LastUpdated time.Time `json:"last_updated"`
+}
+
+func validateCommentEncoding(encoding string) error {
+	switch encoding {
+	case "":
+		return errNotSet
+	case "base64-json":
+		return nil
+	}
+	return fmt.Errorf("unsupported comment encoding %q (want base64-json)", encoding)
+}
+
+// encodeRecordComment returns the comment to store on record after updating it to ip,
+// or nil to leave the existing comment untouched
+func encodeRecordComment(encoding, zone, record, ip string) (*string, error) {
+	if encoding == "" {
+		return nil, nil
+	}
+	if err := validateCommentEncoding(encoding); err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(recordMetadata{
+		Tool:        commentTool,
+		Version:     version(),
+		Zone:        zone,
+		Record:      record,
+		LastIP:      ip,
+		LastUpdated: time.Now().UTC().Truncate(time.Second),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error encoding record metadata: %w", err)
+	}
+	comment := base64.StdEncoding.EncodeToString(data)
+	return &comment, nil
+}
+
+// decodeRecordComment parses a comment written by encodeRecordComment
+func decodeRecordComment(comment string) (*recordMetadata, error) {
+	data, err := base64.StdEncoding.DecodeString(comment)
+	if err != nil {
+		return nil, fmt.Errorf("comment is not base64: %w", err)
+	}
+	var metadata recordMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, fmt.Errorf("comment is not record metadata: %w", err)
+	}
+	if metadata.Tool != commentTool {
+		return nil, fmt.Errorf("comment was not written by %s", commentTool)
+	}
+	return &metadata, nil
+}
+
+// metadataTagNames are the record tags maintained by --cloudflare-update-metadata
+var metadataTagNames = []string{"updated-by", "version", "hostname", "updated-at"}
+
+// withMetadataTags returns tags with the --cloudflare-update-metadata tags
+// replaced by ones describing this update, or tags itself when it is not set.
+// Cloudflare tags take the form name:value.
+func withMetadataTags(tags []string) []string {
+	if !viper.GetBool("cloudflare-update-metadata") {
+		return tags
+	}
+
+	var out []string
+	for _, tag := range tags {
+		name, _, _ := strings.Cut(tag, ":")
+		if !slices.Contains(metadataTagNames, name) {
+			out = append(out, tag)
+		}
+	}
+	out = append(out, "updated-by:caddy-ddns", "version:"+version())
+	if hostname, err := os.Hostname(); err == nil {
+		out = append(out, "hostname:"+hostname)
+	}
+	return append(out, "updated-at:"+time.Now().UTC().Format(time.RFC3339))
+}
@@ -0,0 +1,693 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// readConfigFile loads --config, if set, and merges the selected --profile over
+// the file's top-level settings. Flags and env vars still take precedence.
+func readConfigFile() error {
+	path := viper.GetString("config")
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read config file %s: %w", path, err)
+	}
+	if err := applyConfigFile(path, data); err != nil {
+		return err
+	}
+	loadedConfigFile = data
+	return nil
+}
+
+// loadedConfigFile holds the contents of the config file last applied, restored
+// by --config-watch when a changed file is invalid
+var loadedConfigFile []byte
+
+// applyConfigFile replaces the config file settings with data, read from path
+func applyConfigFile(path string, data []byte) error {
+	viper.SetConfigFile(path)
+	if err := viper.ReadConfig(bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("unable to parse config file %s: %w", path, err)
+	}
+
+	profile := viper.GetString("profile")
+	settings := viper.GetStringMap("profiles." + profile)
+	if len(settings) == 0 {
+		if profile != "default" {
+			return fmt.Errorf("profile %q not found in %s", profile, path)
+		}
+		return nil
+	}
+	return viper.MergeConfigMap(settings)
+}
+
+// Config holds the effective configuration merged from flags, env vars and config file
+type Config struct {
+	APIToken                 string
+	ReadToken                string
+	WriteToken               string
+	ZoneName                 string
+	RecordNames              []string
+	RecordType               string
+	ReadBeforeUpdate         bool
+	RecordID                 string
+	CAATag                   string
+	CAAValue                 string
+	CAAFlags                 int
+	PrometheusPushgatewayURL string
+	WebhookURL               string
+	RedisStreamURL           string
+	RedisStreamKey           string
+	RecordContentValidator   string
+	MetricsAddr              string
+	HealthAddr               string
+	HealthStaleThreshold     int
+	UpdateBatchSize          int
+	ZoneLockFile             string
+	ZoneLockNamespaceID      string
+	ZoneLockTTL              time.Duration
+	CloudflareAPIURL         string
+	IPSource                 string
+	IPPluginPath             string
+	IPServices               []string
+	SplitHorizon             bool
+	InternalIPSource         string
+	ExternalIPSource         string
+	TTL                      int
+	GeoRestriction           string
+	GeoAPIURL                string
+	RecordTTLSync            bool
+	MinTTL                   int
+	TTLBeforeUpdate          bool
+	PreUpdateTTL             int
+	TokenRotate              bool
+	TokenRotateBeforeExpiry  time.Duration
+	TokenExtensionDays       int
+	CloudflareHeaders        []string
+	IPValidationRegex        string
+	DNSRecordFilterRegex     string
+	RateLimitSafetyMargin    float64
+	APIThrottle              float64
+	APIThrottleBurst         int
+	CloudflareRetryOnCodes   []string
+	CloudflareIgnoreCodes    []string
+	CloudflareRetryJitter    float64
+	CommentEncoding          string
+	PaginationStrategy       string
+	Interval                 time.Duration
+	MaxErrors                int
+	MinInterval              time.Duration
+	StabilizationCycles      int
+	VaultAuthMethod          string
+	SecretsBackend           string
+	TokenPerZone             bool
+	CredentialCacheFile      string
+	CredentialCacheKey       string
+	CredentialCacheTTL       time.Duration
+}
+
+// loadConfig reads the effective configuration from Viper
+func loadConfig() *Config {
+	return &Config{
+		APIToken:                 viper.GetString("api-token"),
+		ReadToken:                viper.GetString("cloudflare-read-token"),
+		WriteToken:               viper.GetString("cloudflare-write-token"),
+		ZoneName:                 viper.GetString("zone-name"),
+		RecordNames:              splitRecordNames(viper.GetStringSlice("record-name")...),
+		RecordType:               viper.GetString("record-type"),
+		ReadBeforeUpdate:         readBeforeUpdate(),
+		RecordID:                 viper.GetString("record-id"),
+		CAATag:                   viper.GetString("caa-tag"),
+		CAAValue:                 viper.GetString("caa-value"),
+		CAAFlags:                 viper.GetInt("caa-flags"),
+		PrometheusPushgatewayURL: viper.GetString("prometheus-pushgateway-url"),
+		WebhookURL:               viper.GetString("webhook-url"),
+		RedisStreamURL:           viper.GetString("redis-stream-url"),
+		RedisStreamKey:           viper.GetString("redis-stream-key"),
+		RecordContentValidator:   viper.GetString("record-content-validator"),
+		MetricsAddr:              viper.GetString("metrics-addr"),
+		HealthAddr:               viper.GetString("health-addr"),
+		HealthStaleThreshold:     viper.GetInt("health-stale-threshold"),
+		UpdateBatchSize:          viper.GetInt("cloudflare-update-batch-size"),
+		ZoneLockFile:             viper.GetString("cloudflare-zone-lock-file"),
+		ZoneLockNamespaceID:      viper.GetString("cloudflare-zone-lock-namespace-id"),
+		ZoneLockTTL:              viper.GetDuration("cloudflare-zone-lock-ttl"),
+		CloudflareAPIURL:         viper.GetString("cloudflare-api-url"),
+		IPSource:                 viper.GetString("ip-source"),
+		IPPluginPath:             viper.GetString("ip-plugin-path"),
+		IPServices:               httpIPServices(),
+		SplitHorizon:             viper.GetBool("split-horizon"),
+		InternalIPSource:         viper.GetString("internal-ip-source"),
+		ExternalIPSource:         viper.GetString("external-ip-source"),
+		TTL:                      viper.GetInt("ttl"),
+		GeoRestriction:           viper.GetString("cloudflare-geo-restriction"),
+		GeoAPIURL:                viper.GetString("geo-api-url"),
+		RecordTTLSync:            viper.GetBool("record-ttl-sync"),
+		MinTTL:                   viper.GetInt("cloudflare-min-ttl"),
+		TTLBeforeUpdate:          viper.GetBool("cloudflare-record-ttl-before-update"),
+		PreUpdateTTL:             viper.GetInt("pre-update-ttl"),
+		TokenRotate:              viper.GetBool("cloudflare-account-token-rotate"),
+		TokenRotateBeforeExpiry:  viper.GetDuration("cloudflare-token-rotate-before-expiry"),
+		TokenExtensionDays:       viper.GetInt("cloudflare-token-extension-days"),
+		CloudflareHeaders:        viper.GetStringSlice("cloudflare-header"),
+		IPValidationRegex:        viper.GetString("ip-validation-regex"),
+		DNSRecordFilterRegex:     viper.GetString("dns-record-filter-regex"),
+		RateLimitSafetyMargin:    viper.GetFloat64("rate-limit-safety-margin"),
+		APIThrottle:              viper.GetFloat64("cloudflare-api-throttle"),
+		APIThrottleBurst:         viper.GetInt("cloudflare-api-throttle-burst"),
+		CloudflareRetryOnCodes:   viper.GetStringSlice("cloudflare-retry-on-codes"),
+		CloudflareIgnoreCodes:    viper.GetStringSlice("cloudflare-ignore-error-codes"),
+		CloudflareRetryJitter:    viper.GetFloat64("cloudflare-api-retry-jitter"),
+		CommentEncoding:          viper.GetString("cloudflare-comment-encoding"),
+		PaginationStrategy:       viper.GetString("cloudflare-pagination-strategy"),
+		Interval:                 viper.GetDuration("interval"),
+		MaxErrors:                viper.GetInt("max-errors"),
+		MinInterval:              viper.GetDuration("min-interval"),
+		StabilizationCycles:      viper.GetInt("stabilization-cycles"),
+		VaultAuthMethod:          viper.GetString("vault-auth-method"),
+		SecretsBackend:           viper.GetString("secrets-backend"),
+		TokenPerZone:             viper.GetBool("token-per-zone"),
+		CredentialCacheFile:      viper.GetString("credential-cache-file"),
+		CredentialCacheKey:       viper.GetString("credential-cache-key"),
+		CredentialCacheTTL:       viper.GetDuration("credential-cache-ttl"),
+	}
+}
+
+// errNotSet is returned by a check whose value is not configured
+var errNotSet = errors.New("not set")
+
+// configCheck is a single named validation of the configuration
+type configCheck struct {
+	name  string
+	check func() error
+}
+
+// checks returns every validation performed on the configuration. None of them
+// contact Vault, Cloudflare or the IP services.
+func (c *Config) checks() []configCheck {
+	return []configCheck{
+		{"api-token format", func() error { return validateAPIToken(c.APIToken) }},
+		{"cloudflare-read-token format", func() error { return validateAPIToken(c.ReadToken) }},
+		{"cloudflare-write-token format", func() error { return validateAPIToken(c.WriteToken) }},
+		{"zone-name is a valid DNS name", func() error { return validateDNSName(c.ZoneName, false) }},
+		{"record-name values are valid DNS names", func() error {
+			if len(c.RecordNames) == 0 {
+				return errNotSet
+			}
+			var errs []error
+			for _, name := range c.RecordNames {
+				errs = append(errs, validateDNSName(name, true))
+			}
+			return errors.Join(errs...)
+		}},
+		{"record-type is supported", func() error {
+			switch c.RecordType {
+			case "A", "AAAA", "both", "CAA":
+				return nil
+			}
+			return fmt.Errorf("unsupported record type %q (want A, AAAA, both or CAA)", c.RecordType)
+		}},
+		{"record-id is set when records are not read before updating", func() error {
+			if c.ReadBeforeUpdate {
+				return errNotSet
+			}
+			if c.RecordID == "" {
+				return fmt.Errorf("--record-id is required with --skip-prefetch or --read-before-update=false")
+			}
+			if len(c.RecordNames) > 1 {
+				return fmt.Errorf("--record-id identifies a single record but %d record names are configured", len(c.RecordNames))
+			}
+			if c.RecordType != "A" && c.RecordType != "AAAA" {
+				return fmt.Errorf("--record-id needs --record-type A or AAAA, not %s", c.RecordType)
+			}
+			return nil
+		}},
+		{"caa settings are valid", func() error {
+			if c.RecordType != "CAA" {
+				return errNotSet
+			}
+			return validateCAA(c.CAATag, c.CAAValue, c.CAAFlags)
+		}},
+		{"secrets-backend is supported", func() error {
+			switch c.SecretsBackend {
+			case "vault", "env", "flags":
+				return nil
+			}
+			return fmt.Errorf("unsupported secrets backend %q (want vault, env or flags)", c.SecretsBackend)
+		}},
+		{"vault-auth-method is supported", func() error {
+			if c.SecretsBackend != "vault" {
+				return errNotSet
+			}
+			return validateVaultAuthMethod(c.VaultAuthMethod)
+		}},
+		{"prometheus-pushgateway-url is a valid URL", func() error { return validateURL(c.PrometheusPushgatewayURL) }},
+		{"metrics-addr is a host:port address", func() error {
+			if c.MetricsAddr == "" {
+				return errNotSet
+			}
+			if _, _, err := net.SplitHostPort(c.MetricsAddr); err != nil {
+				return fmt.Errorf("invalid --metrics-addr %q: %w", c.MetricsAddr, err)
+			}
+			return nil
+		}},
+		{"health-addr is a host:port address", func() error {
+			if c.HealthAddr == "" {
+				return errNotSet
+			}
+			if _, _, err := net.SplitHostPort(c.HealthAddr); err != nil {
+				return fmt.Errorf("invalid --health-addr %q: %w", c.HealthAddr, err)
+			}
+			if c.HealthStaleThreshold < 1 {
+				return fmt.Errorf("--health-stale-threshold must be at least 1, got %d", c.HealthStaleThreshold)
+			}
+			return nil
+		}},
+		{"zones list is complete", func() error {
+			zones, err := configuredZones()
+			if err == nil && len(zones) == 0 {
+				return errNotSet
+			}
+			return err
+		}},
+		{"credential-cache settings are complete", func() error {
+			if c.CredentialCacheFile == "" {
+				return errNotSet
+			}
+			if c.CredentialCacheKey == "" {
+				return fmt.Errorf("--credential-cache-key (or CF_CREDENTIAL_CACHE_KEY) is required with --credential-cache-file")
+			}
+			if c.CredentialCacheTTL <= 0 {
+				return fmt.Errorf("--credential-cache-ttl %s must be positive", c.CredentialCacheTTL)
+			}
+			return nil
+		}},
+		{"token-per-zone zones each have a token", func() error {
+			if !c.TokenPerZone {
+				return errNotSet
+			}
+			zones, err := configuredZones()
+			if err != nil {
+				return err
+			}
+			if len(zones) == 0 {
+				return fmt.Errorf("--token-per-zone needs the config file's zones list")
+			}
+			var errs []error
+			for _, zone := range zones {
+				if zone.APIToken == "" && zone.VaultPath == "" {
+					errs = append(errs, fmt.Errorf("zone %s needs api-token or vault-path", zone.ZoneName))
+				}
+			}
+			return errors.Join(errs...)
+		}},
+		{"cloudflare-update-batch-size is positive", func() error {
+			if c.UpdateBatchSize < 1 {
+				return fmt.Errorf("--cloudflare-update-batch-size %d must be at least 1", c.UpdateBatchSize)
+			}
+			return nil
+		}},
+		{"cloudflare-zone-lock-* settings are complete", func() error {
+			if c.ZoneLockFile == "" {
+				return errNotSet
+			}
+			if c.ZoneLockNamespaceID == "" {
+				return fmt.Errorf("--cloudflare-zone-lock-namespace-id is required with --cloudflare-zone-lock-file")
+			}
+			if c.ZoneLockTTL < minZoneLockTTL {
+				return fmt.Errorf("--cloudflare-zone-lock-ttl %s must be at least %s", c.ZoneLockTTL, minZoneLockTTL)
+			}
+			return nil
+		}},
+		{"webhook-url is a valid URL", func() error { return validateURL(c.WebhookURL) }},
+		{"redis-stream-url is a valid Redis URL", func() error {
+			if c.RedisStreamURL == "" {
+				return errNotSet
+			}
+			if _, err := redis.ParseURL(c.RedisStreamURL); err != nil {
+				return err
+			}
+			if c.RedisStreamKey == "" {
+				return fmt.Errorf("--redis-stream-key must not be empty")
+			}
+			return nil
+		}},
+		{"cloudflare-geo-restriction is a country code", func() error {
+			if c.GeoRestriction == "" {
+				return errNotSet
+			}
+			if !countryCodePattern.MatchString(c.GeoRestriction) {
+				return fmt.Errorf("%q is not a two-letter country code", c.GeoRestriction)
+			}
+			return validateURL(strings.ReplaceAll(c.GeoAPIURL, "{ip}", "192.0.2.1"))
+		}},
+		{"record-content-validator is a valid URL", func() error { return validateURL(c.RecordContentValidator) }},
+		{"cloudflare-api-url is a valid HTTPS URL", func() error { return validateHTTPSURL(c.CloudflareAPIURL) }},
+		{"ip-source is supported", func() error { return validateIPSource(c.IPSource) }},
+		{"ip-plugin-path can be loaded by this build", func() error {
+			if c.IPPluginPath == "" {
+				return errNotSet
+			}
+			if !pluginsSupported() {
+				return errNoPluginSupport
+			}
+			return nil
+		}},
+		{"ip-services are valid URLs", func() error {
+			if len(c.IPServices) == 0 {
+				return fmt.Errorf("at least one IP service is required")
+			}
+			var errs []error
+			for _, service := range c.IPServices {
+				if err := validateURL(service); err != nil {
+					errs = append(errs, err)
+				}
+			}
+			return errors.Join(errs...)
+		}},
+		{"split-horizon IP sources are supported", func() error {
+			if !c.SplitHorizon {
+				return errNotSet
+			}
+			return errors.Join(validateIPSource(c.InternalIPSource), validateIPSource(c.ExternalIPSource))
+		}},
+		{"interval and max-errors are not negative", func() error {
+			if c.Interval < 0 || c.MaxErrors < 0 {
+				return fmt.Errorf("--interval %s and --max-errors %d must be zero or positive", c.Interval, c.MaxErrors)
+			}
+			return nil
+		}},
+		{"min-interval and stabilization-cycles are not negative", func() error {
+			if c.MinInterval < 0 || c.StabilizationCycles < 0 {
+				return fmt.Errorf("--min-interval %s and --stabilization-cycles %d must be zero or positive", c.MinInterval, c.StabilizationCycles)
+			}
+			return nil
+		}},
+		{"ttl is within Cloudflare limits", func() error { return validateTTL(c.TTL, c.MinTTL) }},
+		{"record-ttl-sync is not combined with ttl", func() error {
+			if !c.RecordTTLSync {
+				return errNotSet
+			}
+			if c.TTL != 0 {
+				return fmt.Errorf("--record-ttl-sync and --ttl both set the record TTL; choose one")
+			}
+			return nil
+		}},
+		{"pre-update-ttl is within Cloudflare limits", func() error {
+			if !c.TTLBeforeUpdate {
+				return errNotSet
+			}
+			if c.PreUpdateTTL < c.MinTTL || c.PreUpdateTTL > maxTTL {
+				return fmt.Errorf("--pre-update-ttl %d must be between the plan minimum of %d and %d seconds", c.PreUpdateTTL, c.MinTTL, maxTTL)
+			}
+			return nil
+		}},
+		{"dns-record-filter-regex compiles", func() error {
+			if c.DNSRecordFilterRegex == "" {
+				return errNotSet
+			}
+			_, err := regexp.Compile(c.DNSRecordFilterRegex)
+			return err
+		}},
+		{"ip-validation-regex compiles", func() error {
+			_, err := regexp.Compile(c.IPValidationRegex)
+			return err
+		}},
+		{"cloudflare-api-throttle settings are valid", func() error {
+			if c.APIThrottle < 0 {
+				return fmt.Errorf("--cloudflare-api-throttle %v must not be negative", c.APIThrottle)
+			}
+			if c.APIThrottleBurst < 1 {
+				return fmt.Errorf("--cloudflare-api-throttle-burst %d must be at least 1", c.APIThrottleBurst)
+			}
+			return nil
+		}},
+		{"cloudflare-account-token-rotate settings are valid", func() error {
+			if !c.TokenRotate {
+				return errNotSet
+			}
+			if c.TokenRotateBeforeExpiry <= 0 {
+				return fmt.Errorf("--cloudflare-token-rotate-before-expiry %s must be positive", c.TokenRotateBeforeExpiry)
+			}
+			if c.TokenExtensionDays < 1 {
+				return fmt.Errorf("--cloudflare-token-extension-days %d must be at least 1", c.TokenExtensionDays)
+			}
+			return nil
+		}},
+		{"rate-limit-safety-margin is between 0 and 1", func() error {
+			if c.RateLimitSafetyMargin < 0 || c.RateLimitSafetyMargin >= 1 {
+				return fmt.Errorf("%v is outside [0, 1)", c.RateLimitSafetyMargin)
+			}
+			return nil
+		}},
+		{"cloudflare-retry-on-codes are HTTP error status codes", func() error {
+			_, err := parseStatusCodes(c.CloudflareRetryOnCodes)
+			return err
+		}},
+		{"cloudflare-ignore-error-codes are Cloudflare error codes", func() error {
+			if len(c.CloudflareIgnoreCodes) == 0 {
+				return errNotSet
+			}
+			_, err := parseErrorCodes(c.CloudflareIgnoreCodes)
+			return err
+		}},
+		{"cloudflare-api-retry-jitter is between 0 and 1", func() error {
+			if c.CloudflareRetryJitter < 0 || c.CloudflareRetryJitter > 1 {
+				return fmt.Errorf("%v is outside [0, 1]", c.CloudflareRetryJitter)
+			}
+			return nil
+		}},
+		{"cloudflare-comment-encoding is supported", func() error { return validateCommentEncoding(c.CommentEncoding) }},
+		{"cloudflare-pagination-strategy is supported", func() error { return validatePaginationStrategy(c.PaginationStrategy) }},
+		{"cloudflare-header values are well formed", func() error {
+			if len(c.CloudflareHeaders) == 0 {
+				return errNotSet
+			}
+			_, err := parseHeaders(c.CloudflareHeaders)
+			return err
+		}},
+	}
+}
+
+// Validate runs every configuration check and returns all failures joined together
+func (c *Config) Validate() error {
+	var errs []error
+	for _, check := range c.checks() {
+		if err := check.check(); err != nil && !errors.Is(err, errNotSet) {
+			errs = append(errs, fmt.Errorf("%s: %w", check.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validateConfig prints a pass/fail line for each configuration check
+func validateConfig(w io.Writer) error {
+	failed := 0
+	for _, check := range loadConfig().checks() {
+		switch err := check.check(); {
+		case errors.Is(err, errNotSet):
+			fmt.Fprintf(w, "SKIP  %s (not set)\n", check.name)
+		case err != nil:
+			failed++
+			fmt.Fprintf(w, "FAIL  %s: %v\n", check.name, err)
+		default:
+			fmt.Fprintf(w, "PASS  %s\n", check.name)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d configuration check(s) failed", failed)
+	}
+	return nil
+}
+
+// apiTokenPattern matches the 40 character Cloudflare API token format
+var apiTokenPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{40}$`)
+
+func validateAPIToken(token string) error {
+	if token == "" {
+		return errNotSet
+	}
+	if !apiTokenPattern.MatchString(token) {
+		return fmt.Errorf("expected 40 characters of [A-Za-z0-9_-], got %d characters", len(token))
+	}
+	return nil
+}
+
+// Cloudflare TTL limits. A TTL of 1 means "automatic"; the lowest explicit TTL
+// accepted on any plan is 30 seconds (Enterprise), 60 on the others.
+const (
+	automaticTTL  = 1
+	lowestMinTTL  = 30
+	maxTTL        = 86400
+	defaultMinTTL = 60
+)
+
+// validateTTL checks ttl against minTTL (the plan minimum) so that misconfiguration
+// is reported before the Cloudflare API rejects the update with a 422
+func validateTTL(ttl, minTTL int) error {
+	if minTTL < lowestMinTTL {
+		return fmt.Errorf("--cloudflare-min-ttl %d is below Cloudflare's lowest minimum of %d seconds", minTTL, lowestMinTTL)
+	}
+	switch {
+	case ttl == 0:
+		return errNotSet
+	case ttl == automaticTTL:
+		return nil
+	case ttl < minTTL:
+		return fmt.Errorf("--ttl %d is below the plan minimum of %d seconds (use 1 for automatic, or lower --cloudflare-min-ttl on Enterprise plans)", ttl, minTTL)
+	case ttl > maxTTL:
+		return fmt.Errorf("--ttl %d is above Cloudflare's maximum of %d seconds", ttl, maxTTL)
+	}
+	return nil
+}
+
+// countryCodePattern matches an ISO 3166-1 alpha-2 country code
+var countryCodePattern = regexp.MustCompile(`^[A-Za-z]{2}$`)
+
+// dnsLabelPattern matches a single DNS label (underscores are allowed for service records)
+var dnsLabelPattern = regexp.MustCompile(`^[A-Za-z0-9_]([A-Za-z0-9_-]*[A-Za-z0-9_])?$`)
+
+// validateDNSName checks that name is a fully qualified DNS name. Record names may
+// start with a wildcard label.
+func validateDNSName(name string, allowWildcard bool) error {
+	if name == "" {
+		return errNotSet
+	}
+	name = strings.TrimSuffix(name, ".")
+	if len(name) > 253 {
+		return fmt.Errorf("%q is longer than 253 characters", name)
+	}
+
+	labels := strings.Split(name, ".")
+	if len(labels) < 2 {
+		return fmt.Errorf("%q is not a fully qualified domain name", name)
+	}
+	for i, label := range labels {
+		if i == 0 && allowWildcard && label == "*" {
+			continue
+		}
+		if len(label) > 63 {
+			return fmt.Errorf("label %q in %q is longer than 63 characters", label, name)
+		}
+		if !dnsLabelPattern.MatchString(label) {
+			return fmt.Errorf("label %q in %q is not a valid DNS label", label, name)
+		}
+	}
+	return nil
+}
+
+func validateURL(raw string) error {
+	if raw == "" {
+		return errNotSet
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%q must use http or https", raw)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%q has no host", raw)
+	}
+	return nil
+}
+
+func validateHTTPSURL(raw string) error {
+	if err := validateURL(raw); err != nil {
+		return err
+	}
+	if !strings.HasPrefix(raw, "https://") {
+		return fmt.Errorf("%q must use https", raw)
+	}
+	return nil
+}
+
+// redacted replaces secret values in printed configuration
+const redacted = "***REDACTED***"
+
+// secretKeys lists the configuration keys whose values must never be printed
+var secretKeys = map[string]bool{
+	"api-token":              true,
+	"cloudflare-read-token":  true,
+	"cloudflare-write-token": true,
+	// Webhook and Redis URLs commonly embed their credentials
+	"webhook-url":          true,
+	"redis-stream-url":     true,
+	"fritzbox-pass":        true,
+	"credential-cache-key": true,
+	// Extra headers are how API gateways are authenticated (e.g. CF-Access-Client-Secret)
+	"cloudflare-header": true,
+}
+
+// showConfig prints the effective configuration (file, env vars and flags merged)
+// with secret values redacted
+func showConfig(w io.Writer, format string) error {
+	settings := redactSecrets(viper.AllSettings())
+
+	switch format {
+	case "", "yaml":
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		defer enc.Close()
+		return enc.Encode(settings)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(settings)
+	default:
+		return fmt.Errorf("unsupported format %q (want yaml or json)", format)
+	}
+}
+
+// redactSecrets returns a copy of settings with every non-empty secret value replaced
+func redactSecrets(settings map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(settings))
+	for key, value := range settings {
+		if secretKeys[key] {
+			out[key] = value
+			// An empty list has nothing to hide
+			if s := fmt.Sprint(value); s != "" && s != "[]" {
+				out[key] = redacted
+			}
+			continue
+		}
+
+		switch v := value.(type) {
+		case map[string]interface{}:
+			out[key] = redactSecrets(v)
+		case []interface{}:
+			items := make([]interface{}, len(v))
+			for i, item := range v {
+				if m, ok := item.(map[string]interface{}); ok {
+					items[i] = redactSecrets(m)
+				} else {
+					items[i] = item
+				}
+			}
+			out[key] = items
+		default:
+			out[key] = value
+		}
+	}
+	return out
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// writeConfigExample writes a config file in format (yaml or toml) documenting
+// every setting: each key is a flag name, commented out with its default under
+// the flag's help text. Values in the file are overridden by env vars and flags.
+func writeConfigExample(w io.Writer, format string) error {
+	separator := ": "
+	switch format {
+	case "", "yaml":
+	case "toml":
+		separator = " = "
+	default:
+		return fmt.Errorf("unsupported format %q (want yaml or toml)", format)
+	}
+
+	var b strings.Builder
+	b.WriteString("# Settings are named after their flags. Precedence: flags > env vars (CF_<NAME>) > this file > defaults.\n")
+	pflag.VisitAll(func(flag *pflag.Flag) {
+		// The file can't point at itself
+		if flag.Name == "config" {
+			return
+		}
+		fmt.Fprintf(&b, "\n# %s\n#%s%s%s\n", flag.Usage, flag.Name, separator, configValue(flag))
+	})
+
+	b.WriteString("\n# Named profiles override the settings above when selected with --profile\n")
+	if format == "toml" {
+		b.WriteString("#[profiles.staging]\n#zone-name = \"staging.example.com\"\n")
+	} else {
+		b.WriteString("#profiles:\n#  staging:\n#    zone-name: \"staging.example.com\"\n")
+	}
+
+	b.WriteString("\n# Multi-zone mode updates every listed zone concurrently instead of zone-name;\n# api-token is optional and replaces the global API token for its zone. With\n# token-per-zone each zone needs api-token, or vault-path naming a secret in\n# vault-kv-mount whose api-token is read instead\n")
+	if format == "toml" {
+		b.WriteString("#[[zones]]\n#zone-name = \"example.com\"\n#record-name = [\"home.example.com\"]\n#api-token = \"\"\n#vault-path = \"\"\n")
+	} else {
+		b.WriteString("#zones:\n#  - zone-name: \"example.com\"\n#    record-name: [\"home.example.com\"]\n#    api-token: \"\"\n#    vault-path: \"\"\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// configValue renders the default of flag in syntax shared by YAML and TOML
+func configValue(flag *pflag.Flag) string {
+	switch flag.Value.Type() {
+	case "bool", "int", "float64":
+		return flag.DefValue
+	case "stringSlice", "stringArray":
+		// Slice defaults are formatted as [a,b]
+		var items []string
+		if trimmed := strings.Trim(flag.DefValue, "[]"); trimmed != "" {
+			for _, item := range strings.Split(trimmed, ",") {
+				items = append(items, strconv.Quote(item))
+			}
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	default:
+		return strconv.Quote(flag.DefValue)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+)
+
+// watchConfigFile signals on the returned channel whenever --config is written,
+// renamed or replaced. The directory is watched rather than the file, so that
+// editors and Kubernetes ConfigMaps swapping the file in are noticed too.
+func watchConfigFile() (<-chan struct{}, func(), error) {
+	path := filepath.Clean(viper.GetString("config"))
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating config watcher: %w", err)
+	}
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return nil, nil, fmt.Errorf("error watching %s: %w", path, err)
+	}
+
+	changes := make(chan struct{}, 1)
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != path || !event.Has(fsnotify.Write|fsnotify.Rename|fsnotify.Create) {
+					continue
+				}
+				// A reload is already pending when the channel is full
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				slog.Warn("Config watcher error", "error", err)
+			}
+		}
+	}()
+	return changes, func() { watcher.Close() }, nil
+}
+
+// reloadConfigFile re-reads --config and logs the settings that changed. A file
+// that cannot be read or fails validation is rejected and the previous settings kept.
+func reloadConfigFile() {
+	path := viper.GetString("config")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		// A rename leaves no file behind until the replacement is moved in
+		slog.Warn("Unable to read changed config file, keeping the current configuration", "path", path, "error", err)
+		return
+	}
+
+	before := viper.AllSettings()
+	err = applyConfigFile(path, data)
+	if err == nil {
+		err = loadConfig().Validate()
+	}
+	if err != nil {
+		slog.Error("Rejected changed config file, keeping the current configuration", "path", path, "error", err)
+		if err := applyConfigFile(path, loadedConfigFile); err != nil {
+			slog.Error("Unable to restore the previous configuration", "path", path, "error", err)
+		}
+		return
+	}
+	loadedConfigFile = data
+
+	changed := changedSettings(before, viper.AllSettings())
+	if len(changed) == 0 {
+		slog.Debug("Config file changed without changing any settings", "path", path)
+		return
+	}
+	oldValues, newValues := redactSecrets(before), redactSecrets(viper.AllSettings())
+	for _, key := range changed {
+		slog.Info("Configuration changed", "key", key, "old", oldValues[key], "new", newValues[key])
+	}
+}
+
+// changedSettings returns the sorted top-level keys whose values differ
+func changedSettings(before, after map[string]interface{}) []string {
+	var keys []string
+	for key, value := range after {
+		if !reflect.DeepEqual(before[key], value) {
+			keys = append(keys, key)
+		}
+	}
+	for key := range before {
+		if _, ok := after[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// cachedCredentials are the last credentials read from Vault, kept encrypted in
+// --credential-cache-file for when Vault cannot be reached
+type cachedCredentials struct {
+	APIToken   string    `json:"api_token"`
+	RecordName string    `json:"record_name"`
+	ZoneName   string    `json:"zone_name"`
+	CachedAt   time.Time `json:"cached_at"`
+}
+
+// credentialCacheAEAD returns the cipher sealing the cache, keyed by a SHA-256 of
+// --credential-cache-key
+func credentialCacheAEAD() (cipher.AEAD, error) {
+	key := viper.GetString("credential-cache-key")
+	if key == "" {
+		return nil, fmt.Errorf("--credential-cache-key (or CF_CREDENTIAL_CACHE_KEY) is required with --credential-cache-file")
+	}
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// saveCredentialCache encrypts creds into --credential-cache-file, if set
+func saveCredentialCache(creds cachedCredentials) error {
+	path := viper.GetString("credential-cache-file")
+	if path == "" {
+		return nil
+	}
+	aead, err := credentialCacheAEAD()
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := json.Marshal(creds)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, aead.Seal(nonce, nonce, plaintext, nil)); err != nil {
+		return fmt.Errorf("error writing credential cache: %w", err)
+	}
+	return nil
+}
+
+// loadCredentialCache decrypts --credential-cache-file, rejecting credentials
+// cached longer than --credential-cache-ttl ago
+func loadCredentialCache() (cachedCredentials, error) {
+	path := viper.GetString("credential-cache-file")
+	if path == "" {
+		return cachedCredentials{}, fmt.Errorf("no --credential-cache-file is configured")
+	}
+	aead, err := credentialCacheAEAD()
+	if err != nil {
+		return cachedCredentials{}, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cachedCredentials{}, fmt.Errorf("error reading credential cache: %w", err)
+	}
+	if len(data) < aead.NonceSize() {
+		return cachedCredentials{}, errors.New("credential cache is truncated")
+	}
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return cachedCredentials{}, errors.New("credential cache cannot be decrypted with --credential-cache-key")
+	}
+
+	var creds cachedCredentials
+	if err := json.Unmarshal(plaintext, &creds); err != nil {
+		return cachedCredentials{}, fmt.Errorf("credential cache is corrupt: %w", err)
+	}
+	if ttl := viper.GetDuration("credential-cache-ttl"); time.Since(creds.CachedAt) > ttl {
+		return cachedCredentials{}, fmt.Errorf("cached credentials are older than --credential-cache-ttl %s", ttl)
+	}
+	return creds, nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/spf13/viper"
+)
+
+// runDaemon updates once, then again every interval (if positive) and on every
+// trigger (if triggers is not nil). The config file is reloaded on every signal on
+// reloads between updates. Failed updates are logged and retried on the
+// next iteration; after --max-errors consecutive failures an error is returned.
+// Once ctx is done no further update starts.
+func runDaemon(ctx context.Context, interval time.Duration, triggers <-chan updateTrigger, reloads <-chan struct{}) error {
+	var tick <-chan time.Time
+	var timer *time.Timer
+	if interval > 0 {
+		timer = time.NewTimer(interval)
+		defer timer.Stop()
+		tick = timer.C
+	}
+	pace := newAdaptiveInterval(interval, viper.GetDuration("min-interval"), viper.GetInt("stabilization-cycles"))
+
+	maxErrors := viper.GetInt("max-errors")
+	refresh := viper.GetDuration("refresh-credentials-interval")
+	lastRefresh := time.Now()
+	failures := 0
+	ipOverride := ""
+	for {
+		// A failed refresh keeps the previous credentials, counts as a failed cycle
+		// and is retried on the next one
+		var refreshErr error
+		if refresh > 0 && time.Since(lastRefresh) >= refresh {
+			if err := readCredentials(ctx); err != nil {
+				refreshErr = fmt.Errorf("error refreshing credentials, keeping the previous ones: %w", err)
+			} else {
+				lastRefresh = time.Now()
+			}
+		}
+		if viper.GetBool("vault-lease-duration-check") {
+			checkLeaseDuration(interval)
+		}
+
+		if err := errors.Join(refreshErr, runCycle(ctx, ipOverride)); err != nil {
+			failures++
+			slog.Error("Update failed", "consecutive_failures", failures, "error", err)
+			if maxErrors > 0 && failures >= maxErrors {
+				return fmt.Errorf("giving up after %d consecutive failed updates", failures)
+			}
+		} else {
+			failures = 0
+		}
+		if timer != nil {
+			timer.Reset(pace.next(changedRecords.Load() > 0))
+		}
+
+	wait:
+		for {
+			select {
+			case <-tick:
+				ipOverride = ""
+				break wait
+			case trigger, ok := <-triggers:
+				if !ok {
+					return nil
+				}
+				ipOverride = trigger.ip
+				break wait
+			case <-reloads:
+				reloadConfigFile()
+			case <-ctx.Done():
+				slog.Info("Shutting down", "reason", context.Cause(ctx))
+				return nil
+			}
+		}
+	}
+}
+
+// adaptiveInterval shortens the polling interval to min after an IP change, holds
+// it there for stabilization cycles without a further change, then doubles it
+// each cycle until it is back at base
+type adaptiveInterval struct {
+	base, min     time.Duration
+	stabilization int
+	current       time.Duration
+	quiet         int
+}
+
+func newAdaptiveInterval(base, minInterval time.Duration, stabilization int) *adaptiveInterval {
+	return &adaptiveInterval{base: base, min: minInterval, stabilization: stabilization, current: base}
+}
+
+// next returns the wait before the next cycle, given whether the last one changed a record
+func (a *adaptiveInterval) next(changed bool) time.Duration {
+	if a.min <= 0 || a.min >= a.base {
+		return a.base
+	}
+
+	if changed {
+		if a.current != a.min {
+			slog.Info("IP changed, polling more often", "interval", a.min, "stabilization_cycles", a.stabilization)
+		}
+		a.current, a.quiet = a.min, 0
+		return a.current
+	}
+
+	if a.current < a.base {
+		if a.quiet++; a.quiet >= a.stabilization {
+			a.current = min(a.current*2, a.base)
+			slog.Debug("IP stable, lengthening polling interval", "interval", a.current)
+		}
+	}
+	return a.current
+}
+
+// publishedIPs remembers the address last published for each record type, so that
+// repeated cycles only call Cloudflare when the IP actually changes. In multi-zone
+// mode the types are qualified by zone as "<zone>/<type>".
+var publishedIPs = struct {
+	sync.Mutex
+	byType map[string]string
+}{byType: map[string]string{}}
+
+// publishAddress updates the recordType record to ip unless ip was already
+// published by an earlier cycle, or by an earlier run when --state-file is set
+func publishAddress(ctx context.Context, api *cloudflare.API, zone *cloudflare.ResourceContainer, recordType, ip string) error {
+	key := recordType
+	if target, ok := zoneTargetFrom(ctx); ok {
+		key = target.ZoneName + "/" + recordType
+	}
+
+	publishedIPs.Lock()
+	last := publishedIPs.byType[key]
+	publishedIPs.Unlock()
+	if last == ip {
+		slog.Info("IP address unchanged since the last update, skipping record", "type", recordType, "ip", ip)
+		return nil
+	}
+	if allowed, err := checkGeoRestriction(ctx, ip); !allowed {
+		return err
+	}
+
+	err := forEachRecordName(ctx, func(name string) error {
+		return updateRecord(ctx, api, zone, name, recordType, ip, "")
+	})
+	if err != nil || viper.GetBool("dry-run") {
+		return err
+	}
+
+	publishedIPs.Lock()
+	publishedIPs.byType[key] = ip
+	publishedIPs.Unlock()
+
+	if path := viper.GetString("state-file"); path != "" {
+		if err := saveStateFile(path); err != nil {
+			slog.Warn("Unable to save state file", "path", path, "error", err)
+		}
+	}
+	return nil
+}
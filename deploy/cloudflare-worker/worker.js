@@ -0,0 +1,17 @@
+// Cloudflare Worker that returns the caller's public IP.
+//
+// Plain text by default; add ?format=json to get {"ip": "..."} for use with
+// --ip-json-path=ip.
+export default {
+  async fetch(request) {
+    const ip = request.headers.get("CF-Connecting-IP") ?? "";
+    const url = new URL(request.url);
+
+    if (url.searchParams.get("format") === "json") {
+      return Response.json({ ip });
+    }
+    return new Response(ip + "\n", {
+      headers: { "content-type": "text/plain" },
+    });
+  },
+};
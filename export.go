@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// cloudflareExport writes the zone's DNS records to outputDir in the given format
+func cloudflareExport(ctx context.Context, format, outputDir string) error {
+	if format != "" && format != "terraform" {
+		return fmt.Errorf("unsupported format %q (want terraform)", format)
+	}
+
+	api, err := newCloudflareAPI(readToken(ctx))
+	if err != nil {
+		return fmt.Errorf("error initializing Cloudflare API: %w", err)
+	}
+
+	zoneName, _ := currentZone(ctx)
+	zoneID, err := lookupZoneID(api, zoneName)
+	if err != nil {
+		return fmt.Errorf("error fetching Zone ID for %s: %w", zoneName, err)
+	}
+
+	records, _, err := listDNSRecords(ctx, api, cloudflare.ZoneIdentifier(zoneID), cloudflare.ListDNSRecordsParams{
+		Order:     "name",
+		Direction: cloudflare.ListDirectionAsc,
+	})
+	if err != nil {
+		return fmt.Errorf("error fetching DNS records: %w", err)
+	}
+
+	if err := os.MkdirAll(outputDir, 0o755); err != nil {
+		return err
+	}
+	if err := writeFile(filepath.Join(outputDir, "dns_records.tf"), func(w io.Writer) error {
+		return writeTerraformRecords(w, records)
+	}); err != nil {
+		return err
+	}
+	if err := writeFile(filepath.Join(outputDir, "terraform.tfvars"), func(w io.Writer) error {
+		return writeTerraformVars(w, zoneID)
+	}); err != nil {
+		return err
+	}
+
+	slog.Info("Exported DNS records", "count", len(records), "zone", zoneName, "output_dir", outputDir)
+	return nil
+}
+
+// writeFile creates path and fills it using write
+func writeFile(path string, write func(io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeTerraformVars writes the variable values. The API token is deliberately
+// left to TF_VAR_cloudflare_api_token so it never lands on disk.
+func writeTerraformVars(w io.Writer, zoneID string) error {
+	_, err := fmt.Fprintf(w, `# Set the API token through the environment:
+#   export TF_VAR_cloudflare_api_token=...
+zone_id = %s
+`, hclString(zoneID))
+	return err
+}
+
+// writeTerraformRecords writes the provider configuration and one cloudflare_record
+// resource per DNS record
+func writeTerraformRecords(w io.Writer, records []cloudflare.DNSRecord) error {
+	fmt.Fprint(w, `variable "zone_id" {
+  type = string
+}
+
+variable "cloudflare_api_token" {
+  type      = string
+  sensitive = true
+}
+
+provider "cloudflare" {
+  api_token = var.cloudflare_api_token
+}
+`)
+
+	used := map[string]int{}
+	for _, record := range records {
+		name := terraformResourceName(record, used)
+
+		fmt.Fprintf(w, "\nresource \"cloudflare_record\" %q {\n", name)
+		fmt.Fprintf(w, "  zone_id = var.zone_id\n")
+		fmt.Fprintf(w, "  name    = %s\n", hclString(record.Name))
+		fmt.Fprintf(w, "  type    = %s\n", hclString(record.Type))
+		if record.Data == nil {
+			fmt.Fprintf(w, "  content = %s\n", hclString(record.Content))
+		}
+		fmt.Fprintf(w, "  ttl     = %d\n", record.TTL)
+		if record.Proxied != nil {
+			fmt.Fprintf(w, "  proxied = %t\n", *record.Proxied)
+		}
+		if record.Priority != nil {
+			fmt.Fprintf(w, "  priority = %d\n", *record.Priority)
+		}
+		if record.Comment != "" {
+			fmt.Fprintf(w, "  comment = %s\n", hclString(record.Comment))
+		}
+		if len(record.Tags) > 0 {
+			tags := make([]string, len(record.Tags))
+			for i, tag := range record.Tags {
+				tags[i] = hclString(tag)
+			}
+			fmt.Fprintf(w, "  tags    = [%s]\n", strings.Join(tags, ", "))
+		}
+		if data, ok := record.Data.(map[string]interface{}); ok {
+			writeTerraformData(w, data)
+		}
+		fmt.Fprint(w, "}\n")
+	}
+	return nil
+}
+
+// writeTerraformData writes the data block of SRV, CAA, LOC and similar records
+func writeTerraformData(w io.Writer, data map[string]interface{}) {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprint(w, "  data {\n")
+	for _, key := range keys {
+		switch v := data[key].(type) {
+		case string:
+			fmt.Fprintf(w, "    %s = %s\n", key, hclString(v))
+		default:
+			fmt.Fprintf(w, "    %s = %v\n", key, v)
+		}
+	}
+	fmt.Fprint(w, "  }\n")
+}
+
+var nonIdentifierChars = regexp.MustCompile(`[^A-Za-z0-9_]+`)
+
+// terraformResourceName derives a unique resource name such as www_example_com_a
+func terraformResourceName(record cloudflare.DNSRecord, used map[string]int) string {
+	name := strings.ToLower(nonIdentifierChars.ReplaceAllString(record.Name+"_"+record.Type, "_"))
+	name = strings.Trim(name, "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "record_" + name
+	}
+
+	used[name]++
+	if n := used[name]; n > 1 {
+		name = fmt.Sprintf("%s_%d", name, n)
+	}
+	return name
+}
+
+// hclString quotes s as an HCL string literal, escaping template sequences
+func hclString(s string) string {
+	quoted := strconv.Quote(s)
+	quoted = strings.ReplaceAll(quoted, "${", "$${")
+	return strings.ReplaceAll(quoted, "%{", "%%{")
+}
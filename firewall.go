@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"regexp"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// firewallAddrPattern finds IPv4/IPv6 addresses and CIDR ranges in a rule expression
+var firewallAddrPattern = regexp.MustCompile(`[0-9A-Fa-f:.]+(/\d{1,3})?`)
+
+// checkFirewall warns when an active blocking firewall rule in the zone matches ip.
+// Errors are logged rather than returned since the update itself has succeeded.
+func checkFirewall(ctx context.Context, api *cloudflare.API, zone *cloudflare.ResourceContainer, ip string) {
+	rules, _, err := api.FirewallRules(ctx, zone, cloudflare.FirewallRuleListParams{})
+	if err != nil {
+		slog.Warn("Unable to list firewall rules", "error", err)
+		return
+	}
+
+	addr := net.ParseIP(ip)
+	for _, rule := range rules {
+		if rule.Paused || rule.Action != "block" {
+			continue
+		}
+		if expressionMatchesIP(rule.Filter.Expression, addr) {
+			slog.Warn("Firewall rule blocks the new IP in its own zone", "rule_id", rule.ID, "description", rule.Description, "ip", ip)
+		}
+	}
+}
+
+// expressionMatchesIP reports whether a filter expression names addr or a range containing it
+func expressionMatchesIP(expression string, addr net.IP) bool {
+	for _, token := range firewallAddrPattern.FindAllString(expression, -1) {
+		if _, network, err := net.ParseCIDR(token); err == nil {
+			if network.Contains(addr) {
+				return true
+			}
+		} else if ip := net.ParseIP(token); ip != nil && ip.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
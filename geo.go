@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// defaultGeoAPIURL answers with the bare country code of the IP in place of {ip}
+const defaultGeoAPIURL = "https://ipapi.co/{ip}/country/"
+
+// checkGeoRestriction reports whether ip is located in the country of
+// --cloudflare-geo-restriction, logging a warning when it is not, e.g. because the
+// host is routed through a VPN exit node abroad. Only a failed lookup is an error.
+func checkGeoRestriction(ctx context.Context, ip string) (bool, error) {
+	want := viper.GetString("cloudflare-geo-restriction")
+	if want == "" {
+		return true, nil
+	}
+
+	country, err := lookupCountry(ctx, ip)
+	if err != nil {
+		return false, fmt.Errorf("unable to locate IP %s for --cloudflare-geo-restriction: %w", ip, err)
+	}
+	if !strings.EqualFold(country, want) {
+		slog.Warn("IP is outside --cloudflare-geo-restriction, not updating records", "ip", ip, "country", country, "want", strings.ToUpper(want))
+		return false, nil
+	}
+	return true, nil
+}
+
+// lookupCountry asks --geo-api-url for the country code of ip. The service may
+// answer with the bare code or a JSON object with a country_code, countryCode or
+// country field.
+func lookupCountry(ctx context.Context, ip string) (string, error) {
+	apiURL := strings.ReplaceAll(viper.GetString("geo-api-url"), "{ip}", url.PathEscape(ip))
+	body, err := fetchMetadata(ctx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return "", err
+	}
+
+	if !strings.HasPrefix(body, "{") {
+		return body, nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(body), &fields); err != nil {
+		return "", fmt.Errorf("invalid JSON response: %w", err)
+	}
+	for _, key := range []string{"country_code", "countryCode", "country"} {
+		if country, ok := fields[key].(string); ok && country != "" {
+			return country, nil
+		}
+	}
+	return "", fmt.Errorf("response has no country code")
+}
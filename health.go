@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// health tracks the outcome of the most recent update cycle for /healthz
+var health struct {
+	sync.Mutex
+	started    time.Time
+	lastUpdate time.Time // when the last cycle finished
+	lastErr    error
+}
+
+// recordHealth records the outcome of an update cycle that just finished
+func recordHealth(err error) {
+	health.Lock()
+	defer health.Unlock()
+	health.lastUpdate, health.lastErr = time.Now(), err
+}
+
+// serveHealth starts serving /healthz on addr in the background
+func serveHealth(addr string) (func(), error) {
+	health.Lock()
+	health.started = time.Now()
+	health.Unlock()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", handleHealthz)
+	return serveHTTP("health checks", addr, mux)
+}
+
+// handleHealthz answers 200 while the last update cycle succeeded within
+// --health-stale-threshold intervals, and 503 otherwise. Before the first cycle
+// finishes the process counts as healthy for the same period, so a slow first
+// update doesn't fail the probe.
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	health.Lock()
+	started, lastUpdate, lastErr := health.started, health.lastUpdate, health.lastErr
+	health.Unlock()
+
+	// A single update has no later cycles to go stale waiting for
+	var staleAfter time.Duration
+	if interval := viper.GetDuration("interval"); interval > 0 {
+		staleAfter = time.Duration(viper.GetInt("health-stale-threshold")) * interval
+	}
+
+	status, body := http.StatusServiceUnavailable, map[string]string{"status": "degraded"}
+	switch {
+	case lastUpdate.IsZero() && (staleAfter == 0 || time.Since(started) <= staleAfter):
+		status, body["status"] = http.StatusOK, "starting"
+	case lastUpdate.IsZero():
+		body["error"] = "no update cycle has finished"
+	case lastErr != nil:
+		body["error"] = lastErr.Error()
+	case staleAfter > 0 && time.Since(lastUpdate) > staleAfter:
+		body["error"] = fmt.Sprintf("last update finished more than %s ago", staleAfter)
+	default:
+		status, body["status"] = http.StatusOK, "ok"
+		body["last_update"] = lastUpdate.UTC().Format(time.RFC3339)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHandleHealthz(t *testing.T) {
+	tests := []struct {
+		name       string
+		started    time.Duration // ago
+		lastUpdate time.Duration // ago, 0 when no cycle has finished
+		lastErr    error
+		wantCode   int
+		wantStatus string
+	}{
+		{name: "first cycle running", started: time.Minute, wantCode: http.StatusOK, wantStatus: "starting"},
+		{name: "first cycle never finished", started: time.Hour, wantCode: http.StatusServiceUnavailable, wantStatus: "degraded"},
+		{name: "last cycle succeeded", started: time.Hour, lastUpdate: time.Minute, wantCode: http.StatusOK, wantStatus: "ok"},
+		{name: "last cycle failed", started: time.Hour, lastUpdate: time.Minute, lastErr: errors.New("boom"), wantCode: http.StatusServiceUnavailable, wantStatus: "degraded"},
+		{name: "last success is stale", started: time.Hour, lastUpdate: 20 * time.Minute, wantCode: http.StatusServiceUnavailable, wantStatus: "degraded"},
+	}
+
+	setConfig(t, map[string]interface{}{"interval": 5 * time.Minute, "health-stale-threshold": 3})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			health.started, health.lastUpdate, health.lastErr = time.Now().Add(-tt.started), time.Time{}, tt.lastErr
+			if tt.lastUpdate > 0 {
+				health.lastUpdate = time.Now().Add(-tt.lastUpdate)
+			}
+
+			rec := httptest.NewRecorder()
+			handleHealthz(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+			var body map[string]string
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatalf("decoding response: %v", err)
+			}
+			if rec.Code != tt.wantCode || body["status"] != tt.wantStatus {
+				t.Errorf("got %d %v, want %d with status %q", rec.Code, body, tt.wantCode, tt.wantStatus)
+			}
+		})
+	}
+}
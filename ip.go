@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// IPDetector detects the IP to publish. Plugins loaded with --ip-plugin-path
+// export a symbol named IPDetector satisfying this interface.
+type IPDetector interface {
+	DetectIP(ctx context.Context) (string, error)
+}
+
+// IPDetectorFunc adapts an ordinary function to the IPDetector interface
+type IPDetectorFunc func(ctx context.Context) (string, error)
+
+// DetectIP calls f(ctx)
+func (f IPDetectorFunc) DetectIP(ctx context.Context) (string, error) {
+	return f(ctx)
+}
+
+// newIPTransport returns the transport for IP service requests. When network is
+// set ("tcp4" or "tcp6") connections are only made over that address family.
+func newIPTransport(network string) *http.Transport {
+	dialer := &net.Dialer{Timeout: 5 * time.Second, KeepAlive: 30 * time.Second}
+	dial := dialer.DialContext
+	if network != "" {
+		dial = func(ctx context.Context, _, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		}
+	}
+
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dial,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          32,
+		MaxIdleConnsPerHost:   4,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+}
+
+// ipHTTPClient is shared by every IP service and metadata request so that
+// connections (multiplexed over HTTP/2 where the service supports it) are reused
+// across parallel requests and update cycles
+var ipHTTPClient = &http.Client{Transport: newIPTransport("")}
+
+// ipv6HTTPClient only connects over IPv6, so dual-stack services report the IPv6 address
+var ipv6HTTPClient = &http.Client{Transport: newIPTransport("tcp6")}
+
+// ipSources maps --ip-source values to the detector of the IP to publish
+var ipSources = map[string]IPDetector{
+	"http":                    IPDetectorFunc(getPublicIP),
+	"local":                   IPDetectorFunc(getLocalIP),
+	"wireguard":               IPDetectorFunc(getWireGuardEndpointIP),
+	"ec2-metadata":            IPDetectorFunc(getEC2MetadataIP),
+	"gce-metadata":            IPDetectorFunc(getGCEMetadataIP),
+	"azure-metadata":          IPDetectorFunc(getAzureMetadataIP),
+	"azure-instance-metadata": IPDetectorFunc(getAzureInstanceMetadataIP),
+	"cloud-init":              IPDetectorFunc(getCloudInitIP),
+	"stun":                    IPDetectorFunc(getSTUNIP),
+	"netplan":                 IPDetectorFunc(getNetplanIP),
+	"openwrt-uci":             IPDetectorFunc(getOpenWrtUCIIP),
+	"fritzbox":                IPDetectorFunc(getFritzboxIP),
+	"plugin":                  IPDetectorFunc(detectPluginIP),
+}
+
+// primaryIPSource returns the IP source of the record: the plugin when
+// --ip-plugin-path is set, otherwise --ip-source
+func primaryIPSource() string {
+	if viper.GetString("ip-plugin-path") != "" {
+		return "plugin"
+	}
+	return viper.GetString("ip-source")
+}
+
+// Tags identifying the two records maintained in --split-horizon mode
+const (
+	splitHorizonInternalTag = "split-horizon:internal"
+	splitHorizonExternalTag = "split-horizon:external"
+)
+
+// detectIP detects the IP to publish using the named IP source
+func detectIP(ctx context.Context, source string) (string, error) {
+	detector, ok := ipSources[source]
+	if !ok {
+		return "", fmt.Errorf("unknown IP source %q", source)
+	}
+	return detector.DetectIP(ctx)
+}
+
+// validateIPSource checks that source is a registered IP source
+func validateIPSource(source string) error {
+	if _, ok := ipSources[source]; ok {
+		return nil
+	}
+
+	names := make([]string, 0, len(ipSources))
+	for name := range ipSources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("unknown source %q (want one of %v)", source, names)
+}
+
+// httpIPServices returns the --ip-services followed by any --ip-service URLs
+func httpIPServices() []string {
+	services := append([]string{}, viper.GetStringSlice("ip-services")...)
+	return append(services, viper.GetStringSlice("ip-service")...)
+}
+
+// lookupJSONPath returns the string at the dot-separated path in a JSON document
+func lookupJSONPath(body []byte, path string) (string, error) {
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return "", err
+	}
+
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("%q is not an object", key)
+		}
+		if value, ok = object[key]; !ok {
+			return "", fmt.Errorf("key %q not found", key)
+		}
+	}
+
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("value is not a string")
+	}
+	return strings.TrimSpace(s), nil
+}
+
+// fetchMetadata performs a request against a cloud metadata service and returns the
+// trimmed response body. When the body is expected to be an IP it is validated by the caller.
+func fetchMetadata(ctx context.Context, method, url string, header http.Header) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return "", err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	resp, err := ipHTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s %s returned HTTP %d", method, url, resp.StatusCode)
+	}
+
+	return strings.TrimSpace(string(body)), nil
+}
+
+// parseIP checks that s is a valid IP address and returns its canonical form
+func parseIP(s string) (string, error) {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return "", fmt.Errorf("%q is not a valid IP address", s)
+	}
+	return ip.String(), nil
+}
+
+// defaultIPValidationRegex accepts a bare dotted-quad IPv4 address
+const defaultIPValidationRegex = `^(\d{1,3}\.){3}\d{1,3}$`
+
+// embeddedIPv4 finds an IPv4 address anywhere in a response body
+var embeddedIPv4 = regexp.MustCompile(`(\d{1,3}\.){3}\d{1,3}`)
+
+// extractIP validates an IP service response against --ip-validation-regex. When the
+// regex has a capture group named "ip", only that group is taken as the IP.
+func extractIP(body, url string) (string, error) {
+	pattern := viper.GetString("ip-validation-regex")
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", fmt.Errorf("invalid --ip-validation-regex: %w", err)
+	}
+
+	match := re.FindStringSubmatch(body)
+	if match == nil {
+		if pattern == defaultIPValidationRegex && embeddedIPv4.MatchString(body) {
+			slog.Warn("Response contains an IP address but does not match --ip-validation-regex", "url", url, "body", body)
+		}
+		return "", fmt.Errorf("response from %s does not match --ip-validation-regex: %q", url, body)
+	}
+
+	ip := match[0]
+	if i := re.SubexpIndex("ip"); i > 0 {
+		ip = match[i]
+	}
+	return parseIP(ip)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/spf13/viper"
+)
+
+// azureMetadataPublicIPURL is the Azure IMDS path of the primary interface's public IP
+const azureMetadataPublicIPURL = "http://169.254.169.254/metadata/instance/network/interface/0/ipv4/ipAddress/0/publicIpAddress?api-version=2021-02-01"
+
+// getAzureMetadataIP returns the VM's public IP from the Azure Instance Metadata Service
+func getAzureMetadataIP(ctx context.Context) (string, error) {
+	body, err := fetchMetadata(ctx, http.MethodGet, azureMetadataPublicIPURL, http.Header{
+		"Metadata": {"true"},
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to read public IP from Azure metadata: %w", err)
+	}
+
+	var ip string
+	if err := json.Unmarshal([]byte(body), &ip); err != nil {
+		return "", fmt.Errorf("unable to parse Azure metadata response: %w", err)
+	}
+	if ip == "" {
+		return "", fmt.Errorf("no public IP is assigned to this Azure VM")
+	}
+	return parseIP(ip)
+}
+
+// azureInstanceMetadataURL is the Azure IMDS document describing the whole instance
+const azureInstanceMetadataURL = "http://169.254.169.254/metadata/instance?api-version=2021-12-13"
+
+// azureInstanceMetadata is the part of the instance document holding the interfaces' IPs
+type azureInstanceMetadata struct {
+	Network struct {
+		Interface []struct {
+			IPv4 struct {
+				IPAddress []struct {
+					PrivateIPAddress string `json:"privateIpAddress"`
+					PublicIPAddress  string `json:"publicIpAddress"`
+				} `json:"ipAddress"`
+			} `json:"ipv4"`
+		} `json:"interface"`
+	} `json:"network"`
+}
+
+// getAzureInstanceMetadataIP returns the public IP of interface --azure-interface-index
+// from the Azure instance metadata document
+func getAzureInstanceMetadataIP(ctx context.Context) (string, error) {
+	body, err := fetchMetadata(ctx, http.MethodGet, azureInstanceMetadataURL, http.Header{
+		"Metadata": {"true"},
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to read Azure instance metadata: %w", err)
+	}
+
+	var metadata azureInstanceMetadata
+	if err := json.Unmarshal([]byte(body), &metadata); err != nil {
+		return "", fmt.Errorf("unable to parse Azure instance metadata: %w", err)
+	}
+
+	index := viper.GetInt("azure-interface-index")
+	interfaces := metadata.Network.Interface
+	if index < 0 || index >= len(interfaces) {
+		return "", fmt.Errorf("--azure-interface-index %d is out of range: the VM has %d network interfaces", index, len(interfaces))
+	}
+	addresses := interfaces[index].IPv4.IPAddress
+	if len(addresses) == 0 || addresses[0].PublicIPAddress == "" {
+		return "", fmt.Errorf("no public IP is assigned to network interface %d of this Azure VM", index)
+	}
+	return parseIP(addresses[0].PublicIPAddress)
+}
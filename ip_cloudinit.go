@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/viper"
+)
+
+// cloudInitIPPaths are the instance-data.json keys holding the public IPv4 address,
+// in order of preference. Datasources differ in whether they expose it under the
+// standardized v1 keys or in their raw metadata.
+var cloudInitIPPaths = []string{
+	"v1.public_ipv4",
+	"ds.meta_data.public_ipv4",
+	"ds.meta_data.public-ipv4",
+	"ds.meta-data.public-ipv4",
+}
+
+// getCloudInitIP returns the public IP recorded by cloud-init in its instance data file
+func getCloudInitIP(ctx context.Context) (string, error) {
+	path := viper.GetString("cloud-init-instance-data")
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("unable to read cloud-init instance data: %w", err)
+	}
+
+	for _, key := range cloudInitIPPaths {
+		if value, err := lookupJSONPath(body, key); err == nil && value != "" {
+			return parseIP(value)
+		}
+	}
+	return "", fmt.Errorf("no public IPv4 address found in %s", path)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/spf13/viper"
+)
+
+// EC2 Instance Metadata Service endpoints
+const (
+	ec2MetadataTokenURL    = "http://169.254.169.254/latest/api/token"
+	ec2MetadataPublicIPURL = "http://169.254.169.254/latest/meta-data/public-ipv4"
+)
+
+// getEC2MetadataIP returns the instance's public IPv4 address from IMDSv2, falling
+// back to IMDSv1 when --ec2-metadata-imds-v1 is set
+func getEC2MetadataIP(ctx context.Context) (string, error) {
+	header := http.Header{}
+
+	token, err := fetchMetadata(ctx, http.MethodPut, ec2MetadataTokenURL, http.Header{
+		"X-Aws-Ec2-Metadata-Token-Ttl-Seconds": {"60"},
+	})
+	switch {
+	case err == nil:
+		header.Set("X-Aws-Ec2-Metadata-Token", token)
+	case viper.GetBool("ec2-metadata-imds-v1"):
+		slog.Warn("Unable to get IMDSv2 session token, falling back to IMDSv1", "error", err)
+	default:
+		return "", fmt.Errorf("unable to get IMDSv2 session token: %w", err)
+	}
+
+	ip, err := fetchMetadata(ctx, http.MethodGet, ec2MetadataPublicIPURL, header)
+	if err != nil {
+		return "", fmt.Errorf("unable to read public IPv4 from EC2 metadata: %w", err)
+	}
+	return parseIP(ip)
+}
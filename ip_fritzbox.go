@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// TR-064 WANIPConnection service of a FRITZ!Box
+const (
+	fritzboxControlPath = "/upnp/control/wanipconnection1"
+	fritzboxService     = "urn:dslforum-org:service:WANIPConnection:1"
+	fritzboxAction      = "GetExternalIPAddress"
+)
+
+// fritzboxEnvelope is the SOAP response of GetExternalIPAddress
+type fritzboxEnvelope struct {
+	Body struct {
+		Response struct {
+			IP string `xml:"NewExternalIPAddress"`
+		} `xml:"GetExternalIPAddressResponse"`
+		Fault *struct {
+			String string `xml:"faultstring"`
+		} `xml:"Fault"`
+	} `xml:"Body"`
+}
+
+// getFritzboxIP asks the FRITZ!Box at --fritzbox-addr for its external IP over
+// TR-064, logging in with --fritzbox-user and --fritzbox-pass when it asks to
+func getFritzboxIP(ctx context.Context) (string, error) {
+	url := strings.TrimSuffix(viper.GetString("fritzbox-addr"), "/") + fritzboxControlPath
+	body := `<?xml version="1.0" encoding="utf-8"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body><u:` + fritzboxAction + ` xmlns:u="` + fritzboxService + `"/></s:Body></s:Envelope>`
+
+	resp, err := postFritzbox(ctx, url, body, "")
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+		user, pass := viper.GetString("fritzbox-user"), viper.GetString("fritzbox-pass")
+		if user == "" {
+			return "", fmt.Errorf("FRITZ!Box requires a login, set --fritzbox-user and --fritzbox-pass")
+		}
+		authorization, err := digestAuthorization(resp.Header.Get("WWW-Authenticate"), http.MethodPost, fritzboxControlPath, user, pass)
+		if err != nil {
+			return "", fmt.Errorf("unable to log in to FRITZ!Box: %w", err)
+		}
+		if resp, err = postFritzbox(ctx, url, body, authorization); err != nil {
+			return "", err
+		}
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	var envelope fritzboxEnvelope
+	if err := xml.Unmarshal(data, &envelope); err != nil {
+		return "", fmt.Errorf("unable to parse FRITZ!Box response (HTTP %d): %w", resp.StatusCode, err)
+	}
+	if fault := envelope.Body.Fault; fault != nil {
+		return "", fmt.Errorf("FRITZ!Box %s failed: %s", fritzboxAction, fault.String)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("FRITZ!Box returned HTTP %d", resp.StatusCode)
+	}
+	if envelope.Body.Response.IP == "" {
+		return "", fmt.Errorf("FRITZ!Box has no external IP (is the internet connection up?)")
+	}
+	return parseIP(envelope.Body.Response.IP)
+}
+
+func postFritzbox(ctx context.Context, url, body, authorization string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", fritzboxService+"#"+fritzboxAction)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	resp, err := ipHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to reach FRITZ!Box: %w", err)
+	}
+	return resp, nil
+}
+
+// digestParamPattern matches the key=value and key="value" pairs of a Digest challenge
+var digestParamPattern = regexp.MustCompile(`(\w+)=(?:"([^"]*)"|([^,\s]*))`)
+
+// digestAuthorization answers an HTTP Digest challenge (RFC 2617, MD5 with
+// qop=auth), the login scheme of TR-064
+func digestAuthorization(challenge, method, uri, user, pass string) (string, error) {
+	scheme, rest, _ := strings.Cut(challenge, " ")
+	if !strings.EqualFold(scheme, "Digest") {
+		return "", fmt.Errorf("unsupported authentication scheme %q", scheme)
+	}
+	params := map[string]string{}
+	for _, m := range digestParamPattern.FindAllStringSubmatch(rest, -1) {
+		params[strings.ToLower(m[1])] = m[2] + m[3]
+	}
+	if params["nonce"] == "" {
+		return "", fmt.Errorf("digest challenge has no nonce")
+	}
+	if algorithm := params["algorithm"]; algorithm != "" && !strings.EqualFold(algorithm, "MD5") {
+		return "", fmt.Errorf("unsupported digest algorithm %q", algorithm)
+	}
+
+	md5hex := func(s string) string {
+		sum := md5.Sum([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	ha1 := md5hex(user + ":" + params["realm"] + ":" + pass)
+	ha2 := md5hex(method + ":" + uri)
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `Digest username=%q, realm=%q, nonce=%q, uri=%q`, user, params["realm"], params["nonce"], uri)
+	if strings.Contains(params["qop"], "auth") {
+		cnonce := make([]byte, 8)
+		if _, err := rand.Read(cnonce); err != nil {
+			return "", err
+		}
+		nc, cn := "00000001", hex.EncodeToString(cnonce)
+		fmt.Fprintf(&b, `, qop=auth, nc=%s, cnonce=%q, response=%q`, nc, cn, md5hex(ha1+":"+params["nonce"]+":"+nc+":"+cn+":auth:"+ha2))
+	} else {
+		fmt.Fprintf(&b, `, response=%q`, md5hex(ha1+":"+params["nonce"]+":"+ha2))
+	}
+	if opaque := params["opaque"]; opaque != "" {
+		fmt.Fprintf(&b, `, opaque=%q`, opaque)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// gceMetadataExternalIPURL is the GCE metadata path of the primary interface's external IP
+const gceMetadataExternalIPURL = "http://metadata.google.internal/computeMetadata/v1/instance/network-interfaces/0/access-configs/0/external-ip"
+
+// gceMetadataTimeout bounds how long we wait for the metadata server before
+// falling back to the external HTTP services
+const gceMetadataTimeout = time.Second
+
+// getGCEMetadataIP returns the instance's external IP from the GCE metadata server,
+// falling back to the HTTP services when the metadata server is unreachable
+func getGCEMetadataIP(ctx context.Context) (string, error) {
+	metadataCtx, cancel := context.WithTimeout(ctx, gceMetadataTimeout)
+	defer cancel()
+
+	ip, err := fetchMetadata(metadataCtx, http.MethodGet, gceMetadataExternalIPURL, http.Header{
+		"Metadata-Flavor": {"Google"},
+	})
+	if err != nil {
+		slog.Warn("GCE metadata server unavailable, falling back to HTTP services", "error", err)
+		return getPublicIP(ctx)
+	}
+	return parseIP(ip)
+}
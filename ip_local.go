@@ -0,0 +1,20 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+// getLocalIP returns the address of the interface used for outbound traffic. No
+// packets are sent: connecting a UDP socket only selects the route.
+func getLocalIP(ctx context.Context) (string, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", "192.0.2.1:9")
+	if err != nil {
+		return "", fmt.Errorf("unable to determine local IP: %w", err)
+	}
+	defer conn.Close()
+
+	return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
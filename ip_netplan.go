@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// netplanGlob matches the Netplan configuration files, applied in lexical order
+const netplanGlob = "/etc/netplan/*.yaml"
+
+// netplanDeviceTypes are the sections of a Netplan config that define interfaces
+var netplanDeviceTypes = []string{"ethernets", "bonds", "bridges", "vlans", "wifis", "tunnels"}
+
+// netplanConfig is the part of a Netplan file needed to find static addresses.
+// Addresses are either plain strings or single-key maps carrying options.
+type netplanConfig struct {
+	Network map[string]interface{} `yaml:"network"`
+}
+
+// getNetplanIP returns the static IPv4 address Netplan assigns to --netplan-interface,
+// preferring a public address over private ones
+func getNetplanIP(ctx context.Context) (string, error) {
+	iface := viper.GetString("netplan-interface")
+	if iface == "" {
+		return "", fmt.Errorf("--netplan-interface must be set for --ip-source=netplan")
+	}
+
+	files, err := filepath.Glob(netplanGlob)
+	if err != nil {
+		return "", err
+	}
+
+	// Later files override earlier ones, as in netplan itself
+	var addresses []string
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("unable to read Netplan config: %w", err)
+		}
+		var config netplanConfig
+		if err := yaml.Unmarshal(data, &config); err != nil {
+			return "", fmt.Errorf("unable to parse %s: %w", file, err)
+		}
+		if found, ok := netplanAddresses(config, iface); ok {
+			addresses = found
+		}
+	}
+
+	var private string
+	for _, address := range addresses {
+		ip, _, err := net.ParseCIDR(address)
+		if err != nil {
+			ip = net.ParseIP(address)
+		}
+		if ip == nil || ip.To4() == nil {
+			continue
+		}
+		if !ip.IsPrivate() && ip.IsGlobalUnicast() {
+			return ip.String(), nil
+		}
+		if private == "" {
+			private = ip.String()
+		}
+	}
+	if private != "" {
+		return private, nil
+	}
+	return "", fmt.Errorf("no static IPv4 address configured for %s in %s", iface, netplanGlob)
+}
+
+// netplanAddresses returns the addresses listed for iface, if config defines it
+func netplanAddresses(config netplanConfig, iface string) ([]string, bool) {
+	for _, deviceType := range netplanDeviceTypes {
+		devices, _ := config.Network[deviceType].(map[string]interface{})
+		device, ok := devices[iface].(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		list, _ := device["addresses"].([]interface{})
+		addresses := make([]string, 0, len(list))
+		for _, item := range list {
+			switch v := item.(type) {
+			case string:
+				addresses = append(addresses, strings.TrimSpace(v))
+			case map[string]interface{}:
+				for address := range v {
+					addresses = append(addresses, address)
+				}
+			}
+		}
+		return addresses, true
+	}
+	return nil, false
+}
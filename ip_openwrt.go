@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// openWrtWANCommand prints the WAN address configured on an OpenWrt router
+const openWrtWANCommand = "uci get network.wan.ipaddr"
+
+// getOpenWrtUCIIP logs in to --openwrt-host over SSH and returns the WAN IP
+// reported by UCI
+func getOpenWrtUCIIP(ctx context.Context) (string, error) {
+	host, user, keyPath := viper.GetString("openwrt-host"), viper.GetString("openwrt-user"), viper.GetString("openwrt-key")
+	if host == "" || keyPath == "" {
+		return "", fmt.Errorf("--openwrt-host and --openwrt-key are required for --ip-source=openwrt-uci")
+	}
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "22")
+	}
+
+	key, err := os.ReadFile(keyPath)
+	if err != nil {
+		return "", fmt.Errorf("unable to read --openwrt-key: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return "", fmt.Errorf("invalid --openwrt-key: %w", err)
+	}
+	hostKeys, err := openWrtHostKeyCallback()
+	if err != nil {
+		return "", err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return "", fmt.Errorf("unable to connect to OpenWrt router %s: %w", host, err)
+	}
+	// Bound the handshake and command by ctx as well as the dial
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, host, &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeys,
+	})
+	if err != nil {
+		conn.Close()
+		return "", fmt.Errorf("SSH login to OpenWrt router %s failed: %w", host, err)
+	}
+	client := ssh.NewClient(sshConn, chans, reqs)
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return "", fmt.Errorf("unable to open SSH session on %s: %w", host, err)
+	}
+	defer session.Close()
+
+	out, err := session.Output(openWrtWANCommand)
+	if err != nil {
+		return "", fmt.Errorf("%q failed on %s: %w", openWrtWANCommand, host, err)
+	}
+
+	// A static WAN may list several addresses; the first is the primary one
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("no WAN address is configured on %s", host)
+	}
+	ip := fields[0]
+	if addr, _, err := net.ParseCIDR(ip); err == nil {
+		ip = addr.String()
+	}
+	if net.ParseIP(ip) == nil {
+		return "", fmt.Errorf("%q on %s returned %q, which is not an IP", openWrtWANCommand, host, ip)
+	}
+	return ip, nil
+}
+
+// openWrtHostKeyCallback verifies the router against --openwrt-known-hosts,
+// defaulting to ~/.ssh/known_hosts
+func openWrtHostKeyCallback() (ssh.HostKeyCallback, error) {
+	path := viper.GetString("openwrt-known-hosts")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("unable to locate known_hosts, set --openwrt-known-hosts: %w", err)
+		}
+		path = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	callback, err := knownhosts.New(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read SSH known hosts: %w", err)
+	}
+	return callback, nil
+}
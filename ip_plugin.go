@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"plugin"
+	"runtime/debug"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// ipPlugin is loaded from --ip-plugin-path the first time it is needed
+var ipPlugin struct {
+	once     sync.Once
+	detector IPDetector
+	err      error
+}
+
+// detectPluginIP detects the IP using the plugin at --ip-plugin-path
+func detectPluginIP(ctx context.Context) (string, error) {
+	ipPlugin.once.Do(func() {
+		ipPlugin.detector, ipPlugin.err = loadIPPlugin(viper.GetString("ip-plugin-path"))
+	})
+	if ipPlugin.err != nil {
+		return "", ipPlugin.err
+	}
+
+	ip, err := ipPlugin.detector.DetectIP(ctx)
+	if err != nil {
+		return "", err
+	}
+	return parseIP(ip)
+}
+
+// loadIPPlugin opens the Go plugin at path and returns its exported IPDetector
+func loadIPPlugin(path string) (IPDetector, error) {
+	if path == "" {
+		return nil, fmt.Errorf("--ip-plugin-path is required for --ip-source=plugin")
+	}
+
+	if !pluginsSupported() {
+		return nil, errNoPluginSupport
+	}
+
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open IP plugin %s: %w", path, err)
+	}
+
+	sym, err := p.Lookup("IPDetector")
+	if err != nil {
+		return nil, fmt.Errorf("IP plugin %s: %w", path, err)
+	}
+
+	detector, ok := sym.(IPDetector)
+	if !ok {
+		return nil, fmt.Errorf("IP plugin %s: symbol IPDetector (%T) does not implement DetectIP(context.Context) (string, error)", path, sym)
+	}
+	return detector, nil
+}
+
+// errNoPluginSupport is returned for --ip-plugin-path by binaries built without
+// cgo, such as the published container image, where plugin.Open is not implemented
+var errNoPluginSupport = errors.New("--ip-plugin-path needs a binary built with CGO_ENABLED=1; the container image has no plugin support")
+
+// pluginsSupported reports whether this binary was built with cgo and can load plugins
+func pluginsSupported() bool {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return true
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "CGO_ENABLED" {
+			return setting.Value == "1"
+		}
+	}
+	return true
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/pion/stun/v3"
+	"github.com/spf13/viper"
+)
+
+// getSTUNIP returns the public IP reported by a STUN Binding request (RFC 5389)
+// to --stun-server, for networks where UDP is open but HTTP is blocked or monitored
+func getSTUNIP(ctx context.Context) (string, error) {
+	server := viper.GetString("stun-server")
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp4", server)
+	if err != nil {
+		return "", fmt.Errorf("unable to reach STUN server %s: %w", server, err)
+	}
+	client, err := stun.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return "", fmt.Errorf("unable to create STUN client: %w", err)
+	}
+	defer client.Close()
+
+	// Do blocks until the transaction completes, so abort it by closing the client
+	stop := context.AfterFunc(ctx, func() { client.Close() })
+	defer stop()
+
+	var ip string
+	var responseErr error
+	err = client.Do(stun.MustBuild(stun.TransactionID, stun.BindingRequest), func(event stun.Event) {
+		if event.Error != nil {
+			responseErr = event.Error
+			return
+		}
+		var addr stun.XORMappedAddress
+		if err := addr.GetFrom(event.Message); err != nil {
+			responseErr = fmt.Errorf("response has no XOR-MAPPED-ADDRESS: %w", err)
+			return
+		}
+		ip = addr.IP.String()
+	})
+	if err == nil {
+		err = responseErr
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return "", fmt.Errorf("STUN binding request to %s failed: %w", server, err)
+	}
+	return parseIP(ip)
+}
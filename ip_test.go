@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestQueryIPServicesMajority(t *testing.T) {
+	tests := []struct {
+		name      string
+		responses map[string]string // service -> IP, "" for a failed request
+		want      string
+		wantErr   bool
+	}{
+		{
+			name:      "all services agree",
+			responses: map[string]string{"a": "203.0.113.7", "b": "203.0.113.7", "c": "203.0.113.7"},
+			want:      "203.0.113.7",
+		},
+		{
+			name:      "majority outvotes one service",
+			responses: map[string]string{"a": "203.0.113.7", "b": "198.51.100.1", "c": "203.0.113.7"},
+			want:      "203.0.113.7",
+		},
+		{
+			name:      "failed service does not count",
+			responses: map[string]string{"a": "203.0.113.7", "b": "", "c": "203.0.113.7"},
+			want:      "203.0.113.7",
+		},
+		{
+			name:      "single service",
+			responses: map[string]string{"a": "203.0.113.7"},
+			want:      "203.0.113.7",
+		},
+		{
+			name:      "tie has no majority",
+			responses: map[string]string{"a": "203.0.113.7", "b": "198.51.100.1"},
+			wantErr:   true,
+		},
+		{
+			name:      "three different answers have no majority",
+			responses: map[string]string{"a": "203.0.113.7", "b": "198.51.100.1", "c": "192.0.2.1"},
+			wantErr:   true,
+		},
+		{
+			name:      "all services fail",
+			responses: map[string]string{"a": "", "b": ""},
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var services []string
+			for service := range tt.responses {
+				services = append(services, service)
+			}
+			fetch := func(ctx context.Context, service string) (string, error) {
+				if ip := tt.responses[service]; ip != "" {
+					return ip, nil
+				}
+				return "", errors.New("unavailable")
+			}
+
+			got, err := queryIPServices(context.Background(), services, fetch)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("queryIPServices() = %q, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("queryIPServices() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("queryIPServices() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetPublicIP(t *testing.T) {
+	var services []string
+	for _, body := range []string{"203.0.113.7\n", "203.0.113.7", "not an ip"} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, body)
+		}))
+		t.Cleanup(srv.Close)
+		services = append(services, srv.URL)
+	}
+	setConfig(t, map[string]interface{}{"ip-services": services})
+
+	got, err := getPublicIP(context.Background())
+	if err != nil {
+		t.Fatalf("getPublicIP() error = %v", err)
+	}
+	if got != "203.0.113.7" {
+		t.Errorf("getPublicIP() = %q, want 203.0.113.7", got)
+	}
+}
+
+// TestGetPublicIPWorkerJSON covers an --ip-service Worker answering in JSON
+func TestGetPublicIPWorkerJSON(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"ip":"203.0.113.7"}`)
+	}))
+	t.Cleanup(srv.Close)
+	setConfig(t, map[string]interface{}{
+		"ip-services":  []string{},
+		"ip-service":   []string{srv.URL},
+		"ip-json-path": "ip",
+	})
+
+	got, err := getPublicIP(context.Background())
+	if err != nil {
+		t.Fatalf("getPublicIP() error = %v", err)
+	}
+	if got != "203.0.113.7" {
+		t.Errorf("getPublicIP() = %q, want 203.0.113.7", got)
+	}
+}
+
+func TestGetFritzboxIP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != fritzboxControlPath || r.Header.Get("SOAPAction") != fritzboxService+"#"+fritzboxAction {
+			http.NotFound(w, r)
+			return
+		}
+		if !strings.HasPrefix(r.Header.Get("Authorization"), `Digest username="admin"`) {
+			w.Header().Set("WWW-Authenticate", `Digest realm="F!Box SOAP-Auth", nonce="4F0A1B2C", algorithm=MD5, qop="auth"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>`+
+			`<u:GetExternalIPAddressResponse xmlns:u="urn:dslforum-org:service:WANIPConnection:1">`+
+			`<NewExternalIPAddress>203.0.113.7</NewExternalIPAddress></u:GetExternalIPAddressResponse></s:Body></s:Envelope>`)
+	}))
+	t.Cleanup(srv.Close)
+	setConfig(t, map[string]interface{}{"fritzbox-addr": srv.URL, "fritzbox-user": "admin", "fritzbox-pass": "secret"})
+
+	got, err := getFritzboxIP(context.Background())
+	if err != nil {
+		t.Fatalf("getFritzboxIP() error = %v", err)
+	}
+	if got != "203.0.113.7" {
+		t.Errorf("getFritzboxIP() = %q, want 203.0.113.7", got)
+	}
+}
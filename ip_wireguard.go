@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/viper"
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// getWireGuardEndpointIP returns the current endpoint IP of the configured WireGuard peer
+func getWireGuardEndpointIP(ctx context.Context) (string, error) {
+	iface := viper.GetString("wireguard-interface")
+	peerKey := viper.GetString("wireguard-peer-key")
+	if iface == "" || peerKey == "" {
+		return "", fmt.Errorf("--wireguard-interface and --wireguard-peer-key are required for --ip-source=wireguard")
+	}
+
+	key, err := wgtypes.ParseKey(peerKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid --wireguard-peer-key: %w", err)
+	}
+
+	client, err := wgctrl.New()
+	if err != nil {
+		return "", fmt.Errorf("unable to open WireGuard control client: %w", err)
+	}
+	defer client.Close()
+
+	device, err := client.Device(iface)
+	if err != nil {
+		return "", fmt.Errorf("unable to query WireGuard interface %s: %w", iface, err)
+	}
+
+	for _, peer := range device.Peers {
+		if peer.PublicKey != key {
+			continue
+		}
+		if peer.Endpoint == nil {
+			return "", fmt.Errorf("WireGuard peer %s on %s has no endpoint", peerKey, iface)
+		}
+		return peer.Endpoint.IP.String(), nil
+	}
+
+	return "", fmt.Errorf("WireGuard peer %s not found on %s", peerKey, iface)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/spf13/viper"
+)
+
+// baseLogger is the logger configured by --log-level and --log-format, before
+// any per-cycle attributes are added
+var baseLogger = slog.Default()
+
+// setupLogging configures the default slog logger from --log-level and --log-format.
+// Output from the standard log package is routed through the same handler.
+func setupLogging() error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(viper.GetString("log-level"))); err != nil {
+		return fmt.Errorf("invalid --log-level: %w", err)
+	}
+	options := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	switch format := viper.GetString("log-format"); format {
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, options)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	default:
+		return fmt.Errorf("unsupported --log-format %q (want text or json)", format)
+	}
+
+	baseLogger = slog.New(handler)
+	slog.SetDefault(baseLogger)
+	return nil
+}
+
+// fatal logs msg with args at error level and exits with status 1
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// newRequestID returns a random RFC 4122 version 4 UUID
+func newRequestID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err) // crypto/rand never fails on supported platforms
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// startRequestLogging tags all log output for the current update cycle with a
+// fresh request_id
+func startRequestLogging() {
+	slog.SetDefault(baseLogger.With("request_id", newRequestID()))
+}
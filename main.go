@@ -2,219 +2,992 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/cloudflare/cloudflare-go"
-	"github.com/hashicorp/vault/api"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
 // Global variables
 var (
-	apiToken   string
-	zoneName   string
-	recordName string
+	apiToken    string
+	zoneName    string
+	recordNames []string
+
+	// apiTokenMu guards apiToken, which tokenReloadTransport replaces while
+	// concurrent record updates read it
+	apiTokenMu sync.RWMutex
+
+	// changedRecords counts the records modified during the current update cycle
+	changedRecords atomic.Int32
 )
 
 // init function for Viper configuration
 func init() {
 	// Bind environment variables and flags using Viper
 	viper.SetEnvPrefix("cf")
+	// --api-token is read from CF_API_TOKEN, --zone-name from CF_ZONE_NAME, ...
+	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
 	viper.AutomaticEnv()
 
 	// Set up flags using pflag (which Viper uses for flag handling)
+	pflag.String("config", "", "Path to a YAML, TOML or JSON config file whose keys are flag names (see the config-example command)")
+	pflag.String("profile", "default", "Named profile from the config file's profiles section to apply")
+	pflag.String("secrets-backend", "vault", "Where the API token, zone and record name are read from: vault, env (CF_API_TOKEN, CF_ZONE_NAME, CF_RECORD_NAME) or flags")
 	pflag.String("api-token", "", "Cloudflare API Token")
+	pflag.String("cloudflare-read-token", "", "API token used to look up zones and records (Zone Read and DNS Read); defaults to --api-token")
+	pflag.String("cloudflare-write-token", "", "API token used only to change records (DNS Write); defaults to --api-token")
 	pflag.String("zone-name", "", "Cloudflare Zone Name")
-	pflag.String("record-name", "", "DNS Record Name")
-	pflag.Parse()
+	pflag.StringSlice("record-name", nil, "DNS record name to update (repeatable or comma-separated)")
+	pflag.Bool("read-before-update", true, "List records before updating them, skipping the write when the IP is unchanged and keeping their TTL and proxy setting; disabling it with --record-id saves an API call but always writes, changing the TTL and proxy setting only when --ttl and --proxied are given")
+	pflag.Bool("skip-prefetch", false, "Update --record-id directly without listing it first (same as --read-before-update=false); its TTL and proxy setting change only when --ttl or --proxied is given")
+	pflag.String("record-id", "", "Cloudflare ID of the --record-name record, required when it is updated without reading it first")
+	pflag.Int("record-workers", 4, "Number of record names updated concurrently")
+	pflag.String("record-type", "A", "DNS record type to update (A, AAAA, both or CAA)")
+	pflag.Bool("cloudflare-record-type-auto", false, "Update the A or AAAA record depending on the detected IP when --record-type is not set")
+	pflag.String("caa-tag", "issue", "CAA property tag for --record-type=CAA (issue, issuewild or iodef)")
+	pflag.String("caa-value", "", "CAA property value for --record-type=CAA (e.g. letsencrypt.org)")
+	pflag.Int("caa-flags", 0, "CAA flags for --record-type=CAA")
+	pflag.String("cloudflare-api-url", defaultCloudflareAPIURL, "Cloudflare API base URL (for custom API gateways)")
+	pflag.Bool("proxied", false, "Proxy records created by this client through Cloudflare; when set, only records in this proxy state are updated (existing records keep their setting)")
+	pflag.Int("ttl", 0, "TTL in seconds for the DNS record (1 for automatic, 0 keeps the current TTL)")
+	pflag.Bool("record-ttl-sync", false, "Give records the minimum TTL of the zone's SOA record, read from its nameservers, instead of --ttl")
+	pflag.Bool("cloudflare-record-ttl-before-update", false, "Lower a record's TTL to --pre-update-ttl and wait out its old TTL before changing its IP, so resolvers pick up the change quickly (each change then takes that long)")
+	pflag.Int("pre-update-ttl", 60, "TTL in seconds set by --cloudflare-record-ttl-before-update until the IP is updated")
+	pflag.Int("cloudflare-min-ttl", defaultMinTTL, "Lowest TTL allowed by the zone's Cloudflare plan (30 on Enterprise)")
+	pflag.StringArray("cloudflare-header", nil, "Extra \"Key: Value\" header sent with every Cloudflare API request (repeatable)")
+	pflag.Bool("cloudflare-update-metadata", false, "Tag changed records with updated-by, version, hostname and updated-at (needs a plan with DNS record tags)")
+	pflag.String("cloudflare-comment-encoding", "", "Store update metadata in the record comment (base64-json; needs a plan allowing comments over 100 characters)")
+	pflag.Bool("cloudflare-zone-analytics", false, "Log the zone's request count and bandwidth for the last hour after each update")
+	pflag.String("cloudflare-pagination-strategy", "page", "How DNS record listings are paginated (page or cursor)")
+	pflag.String("cloudflare-request-log-file", "", "Append every Cloudflare API request and response to this file as JSON lines (credentials redacted)")
+	pflag.Bool("cloudflare-api-audit", false, "Append a JSONL audit record of every Cloudflare API call, with secrets redacted")
+	pflag.String("cloudflare-api-audit-file", "", "File the --cloudflare-api-audit trail is appended to (stderr when empty)")
+	pflag.StringSlice("cloudflare-ignore-error-codes", nil, "Comma-separated Cloudflare API error codes (e.g. 81054) logged as warnings instead of failing the update")
+	pflag.StringSlice("cloudflare-retry-on-codes", defaultRetryOnCodes, "Comma-separated HTTP status codes on which Cloudflare API requests are retried")
+	pflag.Float64("cloudflare-api-retry-jitter", 0.25, "Random fraction (0-1) of each Cloudflare API retry delay added to spread out retries")
+	pflag.Float64("cloudflare-api-throttle", 4, "Sustained Cloudflare API requests per second (4 matches the 1200 per 5 minutes limit; 0 disables)")
+	pflag.Int("cloudflare-api-throttle-burst", 5, "Cloudflare API requests sent at once before --cloudflare-api-throttle applies")
+	pflag.Float64("rate-limit-safety-margin", 0.1, "Pause Cloudflare API calls while fewer than this fraction of the rate limit remains (0 disables)")
+	pflag.Bool("token-per-zone", false, "Use a separate API token for each zone of the config file's zones list (api-token, or vault-path naming a Vault secret holding it), checked for DNS Write on its zone at startup")
+	pflag.Bool("cloudflare-account-token-rotate", false, "At startup, extend the expiry of an API token that expires within --cloudflare-token-rotate-before-expiry (needs API Tokens Read and Write)")
+	pflag.Duration("cloudflare-token-rotate-before-expiry", 24*time.Hour, "How close to its expiry an API token is extended by --cloudflare-account-token-rotate")
+	pflag.Int("cloudflare-token-extension-days", 30, "Days added to an API token's expiry by --cloudflare-account-token-rotate")
+	pflag.Bool("skip-permission-check", false, "Skip verifying the API token's permissions at startup (checked only when the token has API Tokens Read; otherwise it just has to be active)")
+	pflag.String("zone-id-mapping-file", "", "YAML file mapping zone names to zone IDs, used instead of looking zones up")
+	pflag.Bool("update-mapping-file", false, "Add zone IDs looked up from the API to --zone-id-mapping-file")
+	pflag.Int("cloudflare-update-batch-size", 1, "Update up to this many records matching --dns-record-filter-regex per batch API call (1 updates them one at a time)")
+	pflag.String("cloudflare-zone-lock-file", "", "Workers KV key used as a lock so processes updating the same zone take turns (empty disables)")
+	pflag.String("cloudflare-zone-lock-namespace-id", "", "ID of the Workers KV namespace holding --cloudflare-zone-lock-file")
+	pflag.Duration("cloudflare-zone-lock-ttl", time.Minute, "Lease on the zone lock, after which a crashed holder's lock expires (at least 1m)")
+	pflag.String("cloudflare-account-id", "", "Cloudflare account ID (looked up from the API token when omitted)")
+	pflag.String("cloudflare-geo-restriction", "", "Only update records when the detected IP is located in this country (ISO 3166 code, e.g. DE)")
+	pflag.String("geo-api-url", defaultGeoAPIURL, "IP geolocation service for --cloudflare-geo-restriction; {ip} is replaced by the IP")
+	pflag.String("ip-source", "http", "Where to detect the IP to publish (http, local, wireguard, ec2-metadata, gce-metadata, azure-metadata, azure-instance-metadata, cloud-init, stun, netplan, openwrt-uci, fritzbox or plugin)")
+	pflag.Int("azure-interface-index", 0, "Network interface whose public IP --ip-source=azure-instance-metadata publishes")
+	pflag.String("netplan-interface", "", "Interface whose static address is read from /etc/netplan by --ip-source=netplan")
+	pflag.String("stun-server", "stun.l.google.com:19302", "STUN server (host:port) queried by --ip-source=stun")
+	pflag.String("cloud-init-instance-data", "/run/cloud-init/instance-data.json", "cloud-init instance data file read by --ip-source=cloud-init")
+	pflag.String("ip-plugin-path", "", "Go plugin (.so) exporting an IPDetector used instead of --ip-source (needs a cgo build; not supported by the container image)")
+	pflag.Bool("split-horizon", false, "Update separate internal and external records, tagged split-horizon:internal and split-horizon:external")
+	pflag.String("internal-ip-source", "local", "IP source for the internal record in --split-horizon mode")
+	pflag.String("external-ip-source", "http", "IP source for the external record in --split-horizon mode")
+	pflag.String("wireguard-interface", "", "WireGuard interface to query for --ip-source=wireguard (e.g. wg0)")
+	pflag.String("wireguard-peer-key", "", "Public key of the WireGuard peer whose endpoint IP is published")
+	pflag.String("openwrt-host", "", "OpenWrt router (host or host:port) whose WAN IP --ip-source=openwrt-uci reads over SSH")
+	pflag.String("openwrt-user", "root", "SSH user on the OpenWrt router")
+	pflag.String("openwrt-key", "", "SSH private key used to log in to the OpenWrt router")
+	pflag.String("openwrt-known-hosts", "", "known_hosts file the OpenWrt router's host key is verified against (default ~/.ssh/known_hosts)")
+	pflag.String("fritzbox-addr", "http://fritz.box:49000", "TR-064 address of the FRITZ!Box whose external IP --ip-source=fritzbox reads")
+	pflag.String("fritzbox-user", "", "FRITZ!Box user, when the router requires a login for TR-064")
+	pflag.String("fritzbox-pass", "", "Password of --fritzbox-user")
+	pflag.Bool("ec2-metadata-imds-v1", false, "Fall back to IMDSv1 when an IMDSv2 session token cannot be obtained")
+	pflag.String("vault-auth-method", "token", "Vault auth method: token (VAULT_TOKEN), approle (VAULT_ROLE_ID and VAULT_SECRET_ID) or kubernetes")
+	pflag.String("vault-auth-mount", "", "Mount path of the Vault auth method (defaults to the method name)")
+	pflag.String("vault-kubernetes-role", "", "Vault role to log in as with --vault-auth-method=kubernetes, or in a pod when VAULT_TOKEN is not set")
+	pflag.String("vault-kubernetes-token-path", defaultKubernetesTokenPath, "Service account token presented with --vault-auth-method=kubernetes")
+	pflag.String("vault-tls-server-name", "", "Server name used to verify the Vault TLS certificate (SNI override)")
+	pflag.String("vault-agent-socket", "", "Path to a Vault Agent cache unix socket; the agent supplies the token")
+	pflag.String("vault-kv-mount", "secret", "Mount path of the Vault KV secrets engine")
+	pflag.String("vault-secret-path", "cloudflare", "Path of the Cloudflare secret within the KV mount")
+	pflag.String("credential-cache-file", "", "Encrypted file caching the last credentials read from Vault, used when Vault is unavailable (empty disables)")
+	pflag.String("credential-cache-key", "", "Symmetric key encrypting --credential-cache-file; prefer CF_CREDENTIAL_CACHE_KEY over the flag")
+	pflag.Duration("credential-cache-ttl", 24*time.Hour, "How long cached credentials may be used after they were read from Vault")
+	pflag.String("vault-api-token-key", "api-token", "Key of the API token in the Vault secret")
+	pflag.String("vault-zone-name-key", "zone-name", "Key of the zone name in the Vault secret")
+	pflag.String("vault-record-name-key", "record-name", "Key of the record names in the Vault secret")
+	pflag.Int("vault-kv-version", 2, "Version of the Vault KV secrets engine (1 or 2)")
+	pflag.Bool("vault-seal-status-check", false, "Check that Vault is unsealed before reading secrets")
+	pflag.Int("vault-seal-retry", 0, "Times to retry a sealed or unreachable Vault seal status check, with exponential backoff")
+	pflag.Bool("vault-auto-reload-on-401", false, "Re-read the API token from Vault and retry once when Cloudflare rejects it with 401 (--secrets-backend=vault only; zone, read and write tokens are not reloaded)")
+	pflag.String("decrypt-vault-transit-key", "", "Vault transit key used to decrypt the api-token read from the KV secret (empty disables)")
+	pflag.String("vault-transit-mount", "transit", "Mount path of the Vault transit secrets engine")
+	pflag.Int("requests", 20, "Number of requests sent to each IP service by stress-test")
+	pflag.Int("concurrency", 4, "Number of concurrent requests per IP service for stress-test")
+	pflag.String("dns-record-filter-regex", "", "Update every A record in the zone whose name matches this regular expression instead of --record-name")
+	pflag.Int("record-limit", 100, "Abort when more than this many records would be updated in one run")
+	pflag.Bool("record-limit-override", false, "Proceed even when more than --record-limit records would be updated")
+	pflag.Bool("cloudflare-firewall-check", false, "After an update, warn if an active zone firewall rule blocks the new IP")
+	pflag.Bool("cdn-origin-verify", false, "After updating a proxied record, request it through Cloudflare and warn unless the CF-Cache-Status or X-Forwarded-For response header reports the new origin IP")
+	pflag.Bool("purge-dns-cache-on-update", false, "After an update, purge cached content tagged \"dns\" (Enterprise zones only)")
+	pflag.Bool("pre-flight-check", false, "Before changing a record, check that public DNS resolves it to its current Cloudflare content and log when an earlier change has not propagated")
+	pflag.StringSlice("ip-services", ipServices, "Comma-separated HTTP services queried for the public IP; a majority must agree")
+	pflag.StringArray("ip-service", nil, "Additional HTTP service queried for the public IP, e.g. a Cloudflare Worker (repeatable)")
+	pflag.String("ip-json-path", "", "Dot-separated path of the IP in JSON responses from IP services (e.g. ip)")
+	pflag.String("ip-validation-regex", defaultIPValidationRegex, "Regular expression an IP service response must match (a capture group named \"ip\" selects the IP)")
+	pflag.String("trigger-nats-url", "", "NATS server URL; keeps running and updates whenever a message arrives on --trigger-nats-subject")
+	pflag.String("trigger-nats-subject", "caddy.update", "NATS subject whose messages trigger an immediate update")
+	pflag.Duration("interval", 0, "Keep running and update every interval (e.g. 5m); 0 updates once and exits")
+	pflag.Duration("min-interval", 30*time.Second, "Shortest polling interval, used right after an IP change (0 or at least --interval keeps polling at --interval)")
+	pflag.Int("stabilization-cycles", 5, "Cycles without an IP change spent at --min-interval before the interval lengthens back to --interval")
+	pflag.Bool("config-watch", false, "Reload --config when it changes while running continuously, applying it from the next update")
+	pflag.String("state-file", "", "File remembering the last published IP across restarts, skipping the Cloudflare update when it is unchanged")
+	pflag.Duration("refresh-credentials-interval", 0, "Reload credentials from the secrets backend this often when running continuously (0 never reloads)")
+	pflag.Bool("vault-lease-duration-check", false, "Warn before each update when the Vault secret's lease expires before the next update")
+	pflag.Int("max-errors", 0, "Exit non-zero after this many consecutive failed updates when running continuously (0 never gives up)")
+	pflag.Bool("dry-run", false, "Perform every lookup but only log the DNS record changes instead of making them")
+	pflag.Int("no-op-exit-code", 0, "Exit code used when no record needed updating (no effect when running continuously)")
+	pflag.Bool("log-request-id", false, "Tag every log entry of an update cycle with a unique request_id")
+	pflag.String("format", "", "Output format for subcommands (show-config: yaml or json; config-example: yaml or toml; cloudflare-export: terraform)")
+	pflag.String("output-dir", ".", "Directory cloudflare-export writes its files to")
+	pflag.String("record-comment-prefix", "", "Comment prefix marking the records cleanup manages (empty matches the --cloudflare-comment-encoding metadata)")
+	pflag.Bool("yes", false, "Delete without asking for confirmation in cleanup")
+	pflag.String("record-content-validator", "", "URL asked to approve each record change: it receives {\"ip\",\"record\",\"zone\"} and must answer 200 {\"allow\":true}")
+	pflag.String("webhook-url", "", "HTTP(S) endpoint notified with a JSON POST when a record's IP changes or an update fails")
+	pflag.String("redis-stream-url", "", "Redis URL (redis://[:password@]host:port/db) whose --redis-stream-key receives a JSON entry for every record after each update")
+	pflag.String("redis-stream-key", "caddy-ddns", "Redis stream that --redis-stream-url entries are added to")
+	pflag.String("prometheus-pushgateway-url", "", "Prometheus Pushgateway URL to push metrics to after each update")
+	pflag.String("prometheus-job-name", "caddy-ddns", "Job name used when pushing metrics to the Pushgateway")
+	pflag.Duration("prometheus-push-interval", 0, "Interval at which metrics are also pushed in the background (0 disables)")
+	pflag.String("metrics-addr", "", "Address to serve Prometheus metrics on at /metrics (e.g. :9090); empty disables")
+	pflag.String("health-addr", "", "Address to serve the /healthz liveness endpoint on (e.g. :8080); empty disables")
+	pflag.Int("health-stale-threshold", 3, "Intervals after which /healthz reports the last successful update as stale")
+	pflag.String("log-level", "info", "Minimum level of log messages: debug, info, warn or error")
+	pflag.String("log-format", "text", "Log output format: text or json")
 
-	// Bind flags to Viper
+	// Bind flags to Viper. Values are looked up when read, so flags parsed later
+	// by parseFlags still apply.
 	viper.BindPFlags(pflag.CommandLine)
+}
 
-	// Read environment variables if flags are not provided
-	apiToken, recordName, zoneName = retrieveVaultSecret()
+// parseFlags parses the command line, then loads the config file and sets up
+// logging. It runs at the start of main rather than in init so that tests can
+// use the flag defaults without parsing the test binary's arguments.
+func parseFlags() {
+	pflag.Parse()
+
+	if err := readConfigFile(); err != nil {
+		fatal("Unable to load configuration", "error", err)
+	}
+	if err := setupLogging(); err != nil {
+		fatal("Unable to configure logging", "error", err)
+	}
+}
 
-	// Validate required fields
-	if apiToken == "" || zoneName == "" || recordName == "" {
-		fmt.Println("Missing required flags or environment variables:")
-		fmt.Println("CLOUDFLARE_API_TOKEN (or --api-token)")
-		fmt.Println("CLOUDFLARE_ZONE_NAME (or --zone-name)")
-		fmt.Println("CLOUDFLARE_RECORD_NAME (or --record-name)")
-		os.Exit(1)
+// loadCredentials populates the global credentials and exits if any are missing
+func loadCredentials(ctx context.Context) {
+	if err := readCredentials(ctx); err != nil {
+		fatal("Unable to load credentials", "error", err)
 	}
 }
 
-func retrieveVaultSecret() (string, string, string) {
-	config := api.DefaultConfig()
-	config.Address = "http://10.43.80.26:8200" // Use the service name or appropriate URL
+// readCredentials populates the global credentials from --secrets-backend. On
+// error the previous credentials are left in place.
+func readCredentials(ctx context.Context) error {
+	// Every zone brings its own token, so there are no global credentials to read
+	if viper.GetBool("token-per-zone") {
+		if err := loadZoneTokens(); err != nil {
+			return fmt.Errorf("unable to load zone API tokens: %w", err)
+		}
+		return nil
+	}
 
-	// Create a new Vault client
-	client, err := api.NewClient(config)
-	if err != nil {
-		log.Fatalf("unable to initialize Vault client: %v", err)
+	var token, zone string
+	var names []string
+	switch backend := viper.GetString("secrets-backend"); backend {
+	case "vault":
+		var vaultNames string
+		var err error
+		if token, vaultNames, zone, err = retrieveVaultSecret(ctx); err != nil {
+			return err
+		}
+		names = splitRecordNames(vaultNames)
+	case "env":
+		token = os.Getenv("CF_API_TOKEN")
+		zone = os.Getenv("CF_ZONE_NAME")
+		names = splitRecordNames(os.Getenv("CF_RECORD_NAME"))
+	case "flags":
+		token = viper.GetString("api-token")
+		zone = viper.GetString("zone-name")
+		names = splitRecordNames(viper.GetStringSlice("record-name")...)
+	default:
+		return fmt.Errorf("unsupported --secrets-backend %q (want vault, env or flags)", backend)
+	}
+
+	// With separate read and write tokens no general API token is needed
+	if token == "" {
+		token = viper.GetString("cloudflare-write-token")
+	}
+
+	// In multi-zone mode the zones list names the zones and records, and each
+	// zone may bring its own token
+	if zones, err := configuredZones(); err == nil && len(zones) > 0 {
+		for _, z := range zones {
+			if z.APIToken == "" && token == "" {
+				return fmt.Errorf("missing API token for zone %s: set api-token for the zone or a global API token", z.ZoneName)
+			}
+		}
+	} else if token == "" || zone == "" || len(names) == 0 {
+		return fmt.Errorf("missing required credentials (api token set: %t, zone name %q, record names %v): set CF_API_TOKEN (or --api-token), CF_ZONE_NAME (or --zone-name) and CF_RECORD_NAME (or --record-name)",
+			token != "", zone, names)
 	}
 
-	// Set the token for dev mode (using the pre-configured token)
-	client.SetToken("root")
+	setAPIToken(token)
+	zoneName, recordNames = zone, names
+	return nil
+}
+
+// globalAPIToken returns the API token loaded by loadCredentials, or the one
+// tokenReloadTransport re-read from Vault since
+func globalAPIToken() string {
+	apiTokenMu.RLock()
+	defer apiTokenMu.RUnlock()
+	return apiToken
+}
+
+func setAPIToken(token string) {
+	apiTokenMu.Lock()
+	defer apiTokenMu.Unlock()
+	apiToken = token
+}
+
+func main() {
+	parseFlags()
 
-	// Read the secret from the path "secret/myapp"
-	secret, err := client.Logical().Read("/secret/data/cloudflare")
+	// Cancelled on SIGINT/SIGTERM; an update already in flight still completes
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var err error
+	switch cmd := pflag.Arg(0); cmd {
+	case "":
+		run(ctx)
+	case "validate-config":
+		err = validateConfig(os.Stdout)
+	case "stress-test":
+		err = stressTest(ctx, os.Stdout, viper.GetInt("requests"), viper.GetInt("concurrency"))
+	case "list-tokens":
+		loadCredentials(ctx)
+		err = listTokens(ctx, os.Stdout)
+	case "cleanup":
+		loadCredentials(ctx)
+		err = cleanupRecords(ctx, os.Stdin, os.Stdout)
+	case "cloudflare-token-verify":
+		loadCredentials(ctx)
+		if err = verifyToken(ctx, os.Stdout); errors.Is(err, errBroadToken) {
+			slog.Warn(err.Error())
+			os.Exit(2)
+		}
+	case "cloudflare-export":
+		loadCredentials(ctx)
+		err = cloudflareExport(ctx, viper.GetString("format"), viper.GetString("output-dir"))
+	case "version", "build-info":
+		err = printBuildInfo(os.Stdout)
+	case "status":
+		loadCredentials(ctx)
+		err = printStatus(ctx, os.Stdout)
+	case "show-config":
+		err = showConfig(os.Stdout, viper.GetString("format"))
+	case "config-example":
+		err = writeConfigExample(os.Stdout, viper.GetString("format"))
+	default:
+		err = fmt.Errorf("unknown command %q", cmd)
+	}
 	if err != nil {
-		log.Fatalf("unable to read secret: %v", err)
+		fatal("Command failed", "command", pflag.Arg(0), "error", err)
 	}
-	if secret == nil {
-		log.Fatal("no secret found at the specified path")
+}
+
+// run performs a single DNS update, or keeps updating on an interval and on every
+// trigger when either is configured
+func run(ctx context.Context) {
+	if err := loadConfig().Validate(); err != nil {
+		fatal("Invalid configuration", "error", err)
 	}
 
-	secretData, ok := secret.Data["data"].(map[string]interface{})
-	if !ok {
-		log.Fatal("failed to parse secret data")
+	// Answer health checks before the credentials and first update, which may
+	// take a while
+	if addr := viper.GetString("health-addr"); addr != "" {
+		stopHealth, err := serveHealth(addr)
+		if err != nil {
+			fatal("Unable to start health check server", "addr", addr, "error", err)
+		}
+		defer stopHealth()
 	}
+	loadCredentials(ctx)
 
-	log.Printf("Retrieved this data from vault %v\n\n", secretData)
+	// Seed the previously published IPs so an unchanged address needs no API calls
+	if path := viper.GetString("state-file"); path != "" {
+		if err := loadStateFile(path); err != nil {
+			slog.Warn("Ignoring unreadable state file", "path", path, "error", err)
+		}
+	}
 
-	// Extract the API_TOKEN value
-	apiToken, ok := secretData["api-token"].(string)
-	if !ok {
-		log.Fatal("api-token not found or is not a string in the secret")
+	// Push metrics periodically while the update is in progress
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go startMetricsPusher(ctx)
+	if addr := viper.GetString("metrics-addr"); addr != "" {
+		stopMetrics, err := serveMetrics(addr)
+		if err != nil {
+			fatal("Unable to start metrics server", "addr", addr, "error", err)
+		}
+		defer stopMetrics()
 	}
 
-	// Extract the record-name value
-	recordName, ok := secretData["record-name"].(string)
-	if !ok {
-		log.Fatal("record-name not found or is not a string in the secret")
+	if viper.GetBool("token-per-zone") {
+		if err := checkZoneTokens(ctx); err != nil {
+			fatal("Zone API token check failed", "error", err)
+		}
+	}
+	if viper.GetBool("cloudflare-account-token-rotate") {
+		if err := extendTokenExpiries(ctx); err != nil {
+			slog.Warn("Unable to extend API token expiry", "error", err)
+		}
+	}
+	if !viper.GetBool("skip-permission-check") {
+		if err := checkCredentialPermissions(ctx); err != nil {
+			fatal("API token permission check failed", "error", err)
+		}
 	}
 
-	// Extract the API_TOKEN value
-	zoneName, ok := secretData["zone-name"].(string)
-	if !ok {
-		log.Fatal("zone-name not found or is not a string in the secret")
+	// A nil channel never delivers, leaving only the interval
+	var triggers chan updateTrigger
+	if viper.GetString("trigger-nats-url") != "" {
+		triggers = make(chan updateTrigger)
+		closeTrigger, err := subscribeNATSTrigger(triggers)
+		if err != nil {
+			fatal("Unable to subscribe to update triggers", "error", err)
+		}
+		defer closeTrigger()
+	}
+
+	if interval := viper.GetDuration("interval"); interval > 0 || triggers != nil {
+		var reloads <-chan struct{}
+		if viper.GetBool("config-watch") && viper.GetString("config") != "" {
+			changes, stopWatching, err := watchConfigFile()
+			if err != nil {
+				fatal("Unable to watch config file", "error", err)
+			}
+			defer stopWatching()
+			reloads = changes
+		}
+
+		if err := runDaemon(ctx, interval, triggers, reloads); err != nil {
+			fatal("Stopping", "error", err)
+		}
+		return
 	}
 
-	return apiToken, recordName, zoneName
+	if err := runCycle(ctx, ""); err != nil {
+		fatal("Update failed", "error", err)
+	}
+	if code := viper.GetInt("no-op-exit-code"); code != 0 && changedRecords.Load() == 0 && !viper.GetBool("dry-run") {
+		os.Exit(code)
+	}
 }
 
-func main() {
+// runCycle performs one update cycle and records its outcome
+func runCycle(ctx context.Context, ipOverride string) error {
+	if viper.GetBool("log-request-id") {
+		startRequestLogging()
+	}
+	changedRecords.Store(0)
+
+	var err error
+	if zones, _ := configuredZones(); len(zones) > 0 {
+		err = updateZones(ctx, zones, ipOverride)
+	} else if err = updateDNS(ctx, ipOverride); err != nil {
+		notifyUpdateFailed(ctx, err)
+	}
+	recordUpdate(err)
+	recordHealth(err)
+	if viper.GetString("prometheus-pushgateway-url") != "" {
+		if perr := pushMetrics(ctx); perr != nil {
+			slog.Error("Error pushing metrics", "error", perr)
+		}
+	}
+	return err
+}
+
+// updateDNS points the configured DNS record at the current public IP, or at
+// ipOverride when it is set
+func updateDNS(ctx context.Context, ipOverride string) error {
 	// Initialize Cloudflare API client
-	api, err := cloudflare.NewWithAPIToken(apiToken)
+	api, err := newCloudflareAPI(readToken(ctx))
 	if err != nil {
-		log.Fatalf("Error initializing Cloudflare API: %v", err)
+		return fmt.Errorf("error initializing Cloudflare API: %w", err)
 	}
 
 	// Fetch the Zone ID
-	zoneID, err := api.ZoneIDByName(zoneName)
+	zoneName, _ := currentZone(ctx)
+	zoneID, err := lookupZoneID(api, zoneName)
 	if err != nil {
-		log.Fatalf("Error fetching Zone ID for %s: %v", zoneName, err)
+		return fmt.Errorf("error fetching Zone ID for %s: %w", zoneName, err)
 	}
 
-	zone := &cloudflare.ResourceContainer{
-		Level:      cloudflare.ZoneRouteLevel,
-		Identifier: zoneID,
+	// Hold the zone lock for the whole update so processes sharing the zone
+	// don't interleave their reads and writes
+	if viper.GetString("cloudflare-zone-lock-file") != "" && !viper.GetBool("dry-run") {
+		writeAPI, err := newWriteAPI(ctx, api)
+		if err != nil {
+			return fmt.Errorf("error initializing Cloudflare API: %w", err)
+		}
+		lock, err := acquireZoneLock(ctx, writeAPI)
+		if err != nil {
+			return err
+		}
+		defer lock.release()
 	}
 
-	// Create a context with a timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	// Create a context with a timeout. Shutting down does not cancel it, so an
+	// update that has started is not abandoned halfway through.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
 	defer cancel()
 
-	// Fetch public IP
-	ip, err := getPublicIP(ctx)
+	zone, err := resolveResourceContainer(ctx, api, zoneID, "")
 	if err != nil {
-		log.Fatalf("Error fetching public IP: %v", err)
+		return err
 	}
 
-	fmt.Println("Your IP address is ", ip)
+	if viper.GetString("record-type") == "CAA" {
+		return forEachRecordName(ctx, func(name string) error {
+			return updateCAARecord(ctx, api, zone, name)
+		})
+	}
 
-	// List DNS records with the correct container type
-	records, resultInfo, err := api.ListDNSRecords(ctx, zone, cloudflare.ListDNSRecordsParams{
-		Name: recordName,
-		Type: "A",
+	if viper.GetBool("split-horizon") {
+		if ipOverride != "" {
+			slog.Warn("Ignoring IP override in split-horizon mode", "ip", ipOverride)
+		}
+
+		// Update the internal and external views of the record independently
+		var errs []error
+		for _, view := range []struct{ tag, source string }{
+			{splitHorizonInternalTag, viper.GetString("internal-ip-source")},
+			{splitHorizonExternalTag, viper.GetString("external-ip-source")},
+		} {
+			ip, err := detectIP(ctx, view.source)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("error fetching IP for %s: %w", view.tag, err))
+				continue
+			}
+			slog.Info("Detected IP address", "view", view.tag, "ip", ip)
+			// The internal view holds a LAN address, which has no country
+			if view.tag == splitHorizonExternalTag {
+				allowed, err := checkGeoRestriction(ctx, ip)
+				if err != nil {
+					errs = append(errs, err)
+				}
+				if !allowed {
+					continue
+				}
+			}
+
+			err = forEachRecordName(ctx, func(name string) error {
+				return updateRecord(ctx, api, zone, name, "A", ip, view.tag)
+			})
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+	}
+
+	if viper.GetBool("cloudflare-record-type-auto") && !viper.IsSet("record-type") {
+		return updateAutoTypedRecord(ctx, api, zone, ipOverride)
+	}
+
+	switch viper.GetString("record-type") {
+	case "AAAA":
+		return updateAddressRecord(ctx, api, zone, "AAAA", ipOverride)
+	case "both":
+		// Update the A and AAAA records concurrently, reporting both failures
+		var wg sync.WaitGroup
+		errs := make([]error, 2)
+		for i, recordType := range []string{"A", "AAAA"} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs[i] = updateAddressRecord(ctx, api, zone, recordType, ipOverride)
+			}()
+		}
+		wg.Wait()
+		return errors.Join(errs...)
+	default:
+		return updateAddressRecord(ctx, api, zone, "A", ipOverride)
+	}
+}
+
+// updateAddressRecord detects the public address for recordType (A or AAAA) and
+// updates the record. ipOverride is used instead when it is of the same family.
+func updateAddressRecord(ctx context.Context, api *cloudflare.API, zone *cloudflare.ResourceContainer, recordType, ipOverride string) error {
+	if ipOverride != "" && isIPv6(ipOverride) == (recordType == "AAAA") {
+		slog.Info("Using IP address from trigger", "ip", ipOverride)
+		return publishAddress(ctx, api, zone, recordType, ipOverride)
+	}
+
+	if recordType == "AAAA" {
+		ip, err := getPublicIPv6(ctx)
+		if err != nil {
+			return fmt.Errorf("error fetching public IPv6 address: %w", err)
+		}
+		slog.Info("Detected IPv6 address", "ip", ip)
+		return publishAddress(ctx, api, zone, recordType, ip)
+	}
+
+	ip, err := detectIP(ctx, primaryIPSource())
+	if err != nil {
+		return fmt.Errorf("error fetching public IP: %w", err)
+	}
+	slog.Info("Detected IP address", "ip", ip)
+	return publishAddress(ctx, api, zone, recordType, ip)
+}
+
+// updateAutoTypedRecord updates the A or AAAA records depending on the family of the
+// detected IP. It refuses to update when a record name only has a record of the other type.
+func updateAutoTypedRecord(ctx context.Context, api *cloudflare.API, zone *cloudflare.ResourceContainer, ipOverride string) error {
+	ip := ipOverride
+	if ip == "" {
+		var err error
+		ip, err = detectIP(ctx, primaryIPSource())
+		if err != nil {
+			return fmt.Errorf("error fetching public IP: %w", err)
+		}
+	}
+	slog.Info("Detected IP address", "ip", ip)
+
+	recordType := "A"
+	if isIPv6(ip) {
+		recordType = "AAAA"
+	}
+	slog.Debug("Auto-detected record type", "type", recordType, "ip", ip)
+
+	err := forEachRecordName(ctx, func(name string) error {
+		records, _, err := listDNSRecords(ctx, api, zone, cloudflare.ListDNSRecordsParams{Name: name})
+		if err != nil {
+			return fmt.Errorf("error fetching DNS records: %w", err)
+		}
+		var conflict string
+		for _, record := range records {
+			switch record.Type {
+			case recordType:
+				return nil
+			case "A", "AAAA":
+				conflict = record.Type
+			}
+		}
+		if conflict != "" {
+			return fmt.Errorf("%s has a %s record but the detected IP %s needs a %s record", name, conflict, ip, recordType)
+		}
+		return nil
 	})
 	if err != nil {
-		log.Fatalf("Error fetching DNS records: %v", err)
+		return err
+	}
+	return publishAddress(ctx, api, zone, recordType, ip)
+}
+
+// isIPv6 reports whether ip is an IPv6 address
+func isIPv6(ip string) bool {
+	addr := net.ParseIP(ip)
+	return addr != nil && addr.To4() == nil
+}
+
+// updateRecord points the recordType (A or AAAA) record for name at ip. When
+// tag is set only the record carrying that tag is considered. With
+// --dns-record-filter-regex every record of that type in the zone whose name
+// matches is updated instead.
+func updateRecord(ctx context.Context, api *cloudflare.API, zone *cloudflare.ResourceContainer, name, recordType, ip, tag string) error {
+	filter, err := recordFilter()
+	if err != nil {
+		return err
+	}
+
+	if !readBeforeUpdate() && filter == nil && tag == "" {
+		return updateRecordByID(ctx, api, zone, name, recordType, ip)
+	}
+
+	// A stable order keeps pages consistent if the zone changes while paginating
+	params := cloudflare.ListDNSRecordsParams{
+		Name:      name,
+		Type:      recordType,
+		Order:     "name",
+		Direction: cloudflare.ListDirectionAsc,
+	}
+	if filter != nil {
+		params.Name = ""
+	}
+	if tag != "" {
+		params.Tags = []string{tag}
+	}
+	// Only consider records in the proxy state asked for, so a proxied and an
+	// unproxied record of the same name are not mixed up
+	if viper.IsSet("proxied") {
+		params.Proxied = boolPtr(viper.GetBool("proxied"))
+	}
+
+	// List DNS records with the correct container type
+	records, resultInfo, err := listDNSRecords(ctx, api, zone, params)
+	if err != nil {
+		return fmt.Errorf("error fetching DNS records: %w", err)
 	}
 
-	// Optionally, log the resultInfo (for pagination or additional metadata)
-	fmt.Printf("Total records found: %d\n", resultInfo.Total)
+	slog.Debug("Listed DNS records", "type", recordType, "total", resultInfo.Total)
+
+	if filter != nil {
+		records = filterRecords(records, filter)
+		if len(records) == 0 {
+			return fmt.Errorf("no %s records match --dns-record-filter-regex %s", recordType, filter)
+		}
+		if limit := viper.GetInt("record-limit"); len(records) > limit && !viper.GetBool("record-limit-override") {
+			return fmt.Errorf("%d records match --dns-record-filter-regex %s, more than --record-limit %d; check the filter or set --record-limit-override", len(records), filter, limit)
+		}
+
+		if viper.GetInt("cloudflare-update-batch-size") > 1 {
+			return updateRecordsBatched(ctx, api, zone, records, ip)
+		}
+
+		var errs []error
+		for _, record := range records {
+			if err := setRecordContent(ctx, api, zone, record, ip); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+	}
 
 	// Update the DNS record
 	if len(records) > 0 {
 		record := records[0] // Assuming we are working with the first matching record
+		return setRecordContent(ctx, api, zone, record, ip)
+	}
+	return createRecord(ctx, api, zone, name, recordType, ip, tag)
+}
+
+// readBeforeUpdate reports whether records are listed before they are updated
+func readBeforeUpdate() bool {
+	return viper.GetBool("read-before-update") && !viper.GetBool("skip-prefetch")
+}
+
+// updateRecordByID points the --record-id record at ip without listing it first.
+// Its current content is unknown, so the update is sent even if nothing changed.
+func updateRecordByID(ctx context.Context, api *cloudflare.API, zone *cloudflare.ResourceContainer, name, recordType, ip string) error {
+	id := viper.GetString("record-id")
+	if id == "" {
+		return fmt.Errorf("--record-id is required to update %s without reading it first", name)
+	}
+	if _, names := currentZone(ctx); len(names) != 1 {
+		return fmt.Errorf("--record-id identifies a single record but %d record names are configured", len(names))
+	}
 
-		// Check if the IP address needs to be updated
-		if record.Content == ip {
-			fmt.Printf("DNS record already up-to-date for %s: %s\n", recordName, ip)
-			return
+	// Without the current record, only the TTL and proxy state given as flags are
+	// sent; the rest keep their current value
+	record := cloudflare.DNSRecord{
+		ID:   id,
+		Type: recordType,
+		Name: name,
+		TTL:  recordTTL(ctx),
+	}
+	if viper.IsSet("proxied") {
+		record.Proxied = boolPtr(viper.GetBool("proxied"))
+	}
+	return setRecordContent(ctx, api, zone, record, ip)
+}
+
+// createRecord creates the recordType record for name pointing at ip, with
+// --ttl or --record-ttl-sync (automatic when unset), --proxied and, when set, tag
+func createRecord(ctx context.Context, api *cloudflare.API, zone *cloudflare.ResourceContainer, name, recordType, ip, tag string) error {
+	ttl := automaticTTL
+	if t := recordTTL(ctx); t > 0 {
+		ttl = t
+	}
+	params := cloudflare.CreateDNSRecordParams{
+		Type:    recordType,
+		Name:    name,
+		Content: ip,
+		TTL:     ttl,
+		Proxied: boolPtr(viper.GetBool("proxied")),
+	}
+	if tag != "" {
+		params.Tags = []string{tag}
+	}
+	params.Tags = withMetadataTags(params.Tags)
+	if err := checkRecordContent(ctx, name, ip); err != nil {
+		return err
+	}
+	if viper.GetBool("dry-run") {
+		logDryRun("create", recordType, name, "", "", ip, ttl, params.Proxied)
+		return nil
+	}
+
+	writeAPI, err := newWriteAPI(ctx, api)
+	if err != nil {
+		return fmt.Errorf("error initializing Cloudflare API: %w", err)
+	}
+	if _, err := writeAPI.CreateDNSRecord(ctx, zone, params); err != nil {
+		if err := ignoreAPIError(err); err != nil {
+			return fmt.Errorf("error creating %s record %s: %w", recordType, name, err)
 		}
+		return nil
+	}
 
-		record.Content = ip
-		record, err = api.UpdateDNSRecord(ctx, zone, cloudflare.UpdateDNSRecordParams{
-			Type:    record.Type,
-			Name:    record.Name,
-			Content: record.Content,
-			TTL:     record.TTL,
-			Proxied: record.Proxied,
-			ID:      record.ID,
-		})
-		if err != nil {
-			log.Fatalf("Error updating DNS record: %v", err)
+	slog.Info("Created missing DNS record", "type", recordType, "record", name, "ip", ip)
+	changedRecords.Add(1)
+	notifyIPChanged(ctx, name, "", ip)
+	publishRecordEvent(ctx, "created", name, "", ip)
+	return nil
+}
+
+// setRecordContent updates record to point at ip unless it already does
+func setRecordContent(ctx context.Context, api *cloudflare.API, zone *cloudflare.ResourceContainer, record cloudflare.DNSRecord, ip string) error {
+	params, err := recordUpdateParams(ctx, record, ip)
+	if params == nil || err != nil {
+		if err == nil && !viper.GetBool("dry-run") {
+			publishRecordEvent(ctx, "unchanged", record.Name, record.Content, ip)
 		}
+		return err
+	}
+	if viper.GetBool("dry-run") {
+		logDryRun("update", record.Type, record.Name, record.ID, record.Content, params.Content, params.TTL, params.Proxied)
+		return nil
+	}
 
-		fmt.Printf("Successfully updated DNS record for %s to %s\n", recordName, ip)
-	} else {
-		log.Fatalf("No A records found for %s", recordName)
+	writeAPI, err := newWriteAPI(ctx, api)
+	if err != nil {
+		return fmt.Errorf("error initializing Cloudflare API: %w", err)
 	}
+	lowered := lowersTTLBeforeUpdate(record)
+	if lowered {
+		var cancel context.CancelFunc
+		if ctx, cancel, err = lowerTTL(ctx, writeAPI, zone, record); err != nil {
+			return err
+		}
+		defer cancel()
+	}
+	if _, err = writeAPI.UpdateDNSRecord(ctx, zone, *params); err != nil {
+		if lowered {
+			restoreTTL(ctx, writeAPI, zone, record)
+		}
+		if err := ignoreAPIError(err); err != nil {
+			return fmt.Errorf("error updating DNS record %s: %w", record.Name, err)
+		}
+		return nil
+	}
+
+	recordUpdated(ctx, api, zone, record.Name, record.Content, ip, params.Proxied != nil && *params.Proxied)
+	return nil
+}
 
-	if len(records) == 0 {
-		log.Fatalf("No A records found for %s", recordName)
+// logDryRun logs the record change --dry-run makes instead of sending it to Cloudflare
+func logDryRun(action, recordType, name, id, oldContent, newContent string, ttl int, proxied *bool) {
+	slog.Info("Dry run: not sending DNS record "+action,
+		"type", recordType,
+		"record", name,
+		"id", id,
+		"old_content", oldContent,
+		"new_content", newContent,
+		"ttl", ttl,
+		"proxied", proxied != nil && *proxied,
+	)
+}
+
+// recordUpdateParams returns the update pointing record at ip, or nil when the
+// record needs no update
+func recordUpdateParams(ctx context.Context, record cloudflare.DNSRecord, ip string) (*cloudflare.UpdateDNSRecordParams, error) {
+	// Check if the IP address needs to be updated
+	if record.Content == ip {
+		slog.Info("DNS record already up-to-date", "record", record.Name, "ip", ip)
+		return nil, nil
+	}
+
+	// Compare public DNS with the Cloudflare record. The record differs from ip, so
+	// it is updated either way: public DNS already answering ip would only mean a
+	// resolver cache or split DNS is hiding the stale record.
+	if viper.GetBool("pre-flight-check") && record.Content != "" {
+		propagated, err := resolvesTo(ctx, record.Name, record.Content)
+		switch {
+		case err != nil:
+			slog.Warn("Pre-flight DNS lookup failed", "record", record.Name, "error", err)
+		case propagated:
+			slog.Info("Public DNS matches the Cloudflare record", "record", record.Name, "content", record.Content)
+		default:
+			slog.Warn("Public DNS does not match the Cloudflare record; an earlier change may not have propagated", "record", record.Name, "content", record.Content)
+		}
 	}
 
-	fmt.Printf("Successfully updated DNS record for %s to %s\n", recordName, ip)
+	if err := checkRecordContent(ctx, record.Name, ip); err != nil {
+		return nil, err
+	}
+
+	record.Content = ip
+	if ttl := recordTTL(ctx); ttl > 0 {
+		record.TTL = ttl
+	}
+	zoneName, _ := currentZone(ctx)
+	comment, err := encodeRecordComment(viper.GetString("cloudflare-comment-encoding"), zoneName, record.Name, ip)
+	if err != nil {
+		return nil, err
+	}
+	// A nil Proxied leaves the record's proxy state unchanged
+	var proxied *bool
+	if record.Proxied != nil {
+		proxied = boolPtr(*record.Proxied)
+	}
+	return &cloudflare.UpdateDNSRecordParams{
+		Type:    record.Type,
+		Name:    record.Name,
+		Content: record.Content,
+		TTL:     record.TTL,
+		Proxied: proxied,
+		ID:      record.ID,
+		Tags:    withMetadataTags(record.Tags),
+		Comment: comment,
+	}, nil
+}
+
+// recordUpdated counts a record changed from oldIP to ip, notifies --webhook-url
+// and --redis-stream-url and runs the configured post-update checks
+func recordUpdated(ctx context.Context, api *cloudflare.API, zone *cloudflare.ResourceContainer, name, oldIP, ip string, proxied bool) {
+	slog.Info("Updated DNS record", "record", name, "ip", ip)
+	changedRecords.Add(1)
+	notifyIPChanged(ctx, name, oldIP, ip)
+	publishRecordEvent(ctx, "updated", name, oldIP, ip)
+
+	if viper.GetBool("cloudflare-firewall-check") {
+		checkFirewall(ctx, api, zone, ip)
+	}
+	if proxied && viper.GetBool("cdn-origin-verify") {
+		verifyCDNOrigin(ctx, name, ip)
+	}
+	if viper.GetBool("purge-dns-cache-on-update") {
+		purgeDNSCache(ctx, api, zone.Identifier)
+	}
+	if viper.GetBool("cloudflare-zone-analytics") {
+		logZoneAnalytics(ctx, api, zone.Identifier)
+	}
+}
+
+// recordFilter compiles --dns-record-filter-regex, returning nil when it is not set
+func recordFilter() (*regexp.Regexp, error) {
+	pattern := viper.GetString("dns-record-filter-regex")
+	if pattern == "" {
+		return nil, nil
+	}
+	filter, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --dns-record-filter-regex: %w", err)
+	}
+	return filter, nil
+}
+
+// filterRecords returns the records whose name matches filter
+func filterRecords(records []cloudflare.DNSRecord, filter *regexp.Regexp) []cloudflare.DNSRecord {
+	var matched []cloudflare.DNSRecord
+	for _, record := range records {
+		if filter.MatchString(record.Name) {
+			matched = append(matched, record)
+		}
+	}
+	return matched
+}
+
+// ipServices are the default --ip-services queried for the public IP
+var ipServices = []string{
+	"https://checkip.amazonaws.com",
+	"https://icanhazip.com",
+	"https://api.ipify.org",
 }
 
 // getPublicIP retrieves the public IPv4 address from multiple services
 func getPublicIP(ctx context.Context) (string, error) {
-	services := []string{
-		"https://checkip.amazonaws.com",
-		"https://icanhazip.com",
-	}
+	return queryIPServices(ctx, httpIPServices(), fetchIP)
+}
+
+// ipv6Services are queried over IPv6 by getPublicIPv6
+var ipv6Services = []string{
+	"https://api64.ipify.org",
+	"https://icanhazip.com",
+}
 
+// getPublicIPv6 retrieves the public IPv6 address from multiple services
+func getPublicIPv6(ctx context.Context) (string, error) {
+	return queryIPServices(ctx, ipv6Services, fetchIPv6)
+}
+
+// queryIPServices queries every service in parallel with fetch and returns the
+// address reported by a majority of the services that answered. Without a
+// majority it fails, so that a single compromised service can't redirect the record.
+func queryIPServices(ctx context.Context, services []string, fetch func(ctx context.Context, url string) (string, error)) (string, error) {
 	type result struct {
-		ip  string
-		err error
+		service string
+		ip      string
+		err     error
 	}
 
 	results := make(chan result, len(services))
 	for _, url := range services {
 		go func(service string) {
-			ip, err := fetchIP(ctx, service)
-			results <- result{ip, err}
+			ip, err := fetch(ctx, service)
+			results <- result{service, ip, err}
 		}(url)
 	}
 
 	var ips []string
+	var errs []error
 	for range services {
 		res := <-results
 		if res.err == nil {
 			ips = append(ips, res.ip)
+		} else {
+			errs = append(errs, fmt.Errorf("%s: %w", res.service, res.err))
 		}
 	}
 
 	if len(ips) == 0 {
-		return "", fmt.Errorf("failed to fetch public IP from all services")
+		return "", fmt.Errorf("failed to fetch public IP from all services: %w", errors.Join(errs...))
 	}
 
-	if len(ips) > 1 && ips[0] != ips[1] {
-		log.Printf("Warning: IP mismatch between services: %s vs %s. Using %s", ips[0], ips[1], ips[0])
+	votes := make(map[string]int)
+	winner := ""
+	for _, ip := range ips {
+		votes[ip]++
+		if votes[ip] > votes[winner] {
+			winner = ip
+		}
+	}
+	if len(votes) > 1 {
+		slog.Warn("IP mismatch between services", "votes", votes)
+	}
+	if votes[winner]*2 <= len(ips) {
+		return "", fmt.Errorf("no majority among %d IP service responses: %v", len(ips), votes)
 	}
 
-	return ips[0], nil
+	return winner, nil
 }
 
 // fetchIP fetches the public IP from a single service
@@ -224,7 +997,7 @@ func fetchIP(ctx context.Context, url string) (string, error) {
 		return "", err
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := ipHTTPClient.Do(req)
 	if err != nil {
 		return "", err
 	}
@@ -236,9 +1009,40 @@ func fetchIP(ctx context.Context, url string) (string, error) {
 	}
 
 	ip := strings.TrimSpace(string(body))
+	if jsonPath := viper.GetString("ip-json-path"); jsonPath != "" && strings.HasPrefix(ip, "{") {
+		ip, err = lookupJSONPath(body, jsonPath)
+		if err != nil {
+			return "", fmt.Errorf("unable to read %s from %s response: %w", jsonPath, url, err)
+		}
+	}
 	if ip == "" {
 		return "", fmt.Errorf("received empty IP address from %s", url)
 	}
 
-	return ip, nil
+	return extractIP(ip, url)
+}
+
+// fetchIPv6 fetches the public IPv6 address from a single service over IPv6
+func fetchIPv6(ctx context.Context, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := ipv6HTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	ip := strings.TrimSpace(string(body))
+	if !isIPv6(ip) {
+		return "", fmt.Errorf("response from %s is not an IPv6 address: %q", url, ip)
+	}
+	return parseIP(ip)
 }
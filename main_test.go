@@ -0,0 +1,49 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestMain(m *testing.M) {
+	// Flags keep their defaults: parseFlags is never called, and update logs would
+	// only clutter test output
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// setConfig overrides configuration keys for the duration of the test
+func setConfig(t *testing.T, settings map[string]interface{}) {
+	t.Helper()
+	for key, value := range settings {
+		var previous interface{}
+		// A nil override leaves an unset key unset, so viper.IsSet checks still see
+		// the flag as not given
+		if viper.IsSet(key) {
+			previous = viper.Get(key)
+		}
+		viper.Set(key, value)
+		t.Cleanup(func() { viper.Set(key, previous) })
+	}
+}
+
+// setCredentials points the update at zone and records for the duration of the test
+func setCredentials(t *testing.T, token, zone string, records ...string) {
+	t.Helper()
+	previousToken, previousZone, previousRecords := globalAPIToken(), zoneName, recordNames
+	setAPIToken(token)
+	zoneName, recordNames = zone, records
+	t.Cleanup(func() {
+		setAPIToken(previousToken)
+		zoneName, recordNames = previousZone, previousRecords
+	})
+
+	// Each test starts without addresses published by an earlier one
+	publishedIPs.Lock()
+	publishedIPs.byType = map[string]string{}
+	publishedIPs.Unlock()
+}
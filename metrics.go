@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"github.com/spf13/viper"
+)
+
+// Metrics collected during update cycles
+var (
+	metricsRegistry = prometheus.NewRegistry()
+
+	updateTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ddns_update_total",
+		Help: "Total number of update cycles by result.",
+	}, []string{"result"})
+
+	lastUpdateTimestamp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "ddns_last_update_timestamp_seconds",
+		Help: "Unix timestamp of the last successful update cycle.",
+	})
+
+	consecutiveErrors = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "ddns_consecutive_errors",
+		Help: "Number of update cycles that failed in a row since the last success.",
+	})
+)
+
+func init() {
+	metricsRegistry.MustRegister(updateTotal, lastUpdateTimestamp, consecutiveErrors)
+}
+
+// recordUpdate records the outcome of an update cycle
+func recordUpdate(err error) {
+	if err != nil {
+		updateTotal.WithLabelValues("error").Inc()
+		consecutiveErrors.Inc()
+		return
+	}
+	updateTotal.WithLabelValues("success").Inc()
+	lastUpdateTimestamp.SetToCurrentTime()
+	consecutiveErrors.Set(0)
+}
+
+// serveMetrics starts serving /metrics on addr in the background. The returned
+// function shuts the server down, waiting briefly for in-flight scrapes.
+func serveMetrics(addr string) (func(), error) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+	return serveHTTP("metrics", addr, mux)
+}
+
+// serveHTTP serves handler on addr in the background, logging under name. The
+// returned function shuts the server down, waiting briefly for in-flight requests.
+func serveHTTP(name, addr string, handler http.Handler) (func(), error) {
+	// Listen up front so a bad or busy address fails at startup
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	srv := &http.Server{Handler: handler, ReadHeaderTimeout: 5 * time.Second}
+
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("HTTP server stopped", "server", name, "error", err)
+		}
+	}()
+	slog.Info("Serving "+name, "addr", ln.Addr().String())
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			slog.Error("Error shutting down HTTP server", "server", name, "error", err)
+		}
+	}, nil
+}
+
+// pushMetrics pushes the collected metrics to the configured Pushgateway
+func pushMetrics(ctx context.Context) error {
+	return push.New(viper.GetString("prometheus-pushgateway-url"), viper.GetString("prometheus-job-name")).
+		Gatherer(metricsRegistry).
+		PushContext(ctx)
+}
+
+// startMetricsPusher pushes metrics on every --prometheus-push-interval tick until ctx is done
+func startMetricsPusher(ctx context.Context) {
+	interval := viper.GetDuration("prometheus-push-interval")
+	if viper.GetString("prometheus-pushgateway-url") == "" || interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := pushMetrics(ctx); err != nil {
+				slog.Error("Error pushing metrics", "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// zoneTarget is one entry of the config file's zones list. With zones configured
+// every update cycle updates each of them instead of --zone-name.
+type zoneTarget struct {
+	ZoneName    string   `mapstructure:"zone-name"`
+	RecordNames []string `mapstructure:"record-name"`
+	// APIToken replaces the global API token for this zone when set
+	APIToken string `mapstructure:"api-token"`
+	// VaultPath is a secret in --vault-kv-mount whose api-token is this zone's
+	// token in --token-per-zone mode
+	VaultPath string `mapstructure:"vault-path"`
+}
+
+// vaultZoneTokens holds the tokens read from each zone's vault-path at startup
+// and on credential refreshes, which only happen between updates.
+var vaultZoneTokens = map[string]string{}
+
+// zoneTargetKey is the context key of the zone an update is for
+type zoneTargetKey struct{}
+
+func withZoneTarget(ctx context.Context, target zoneTarget) context.Context {
+	return context.WithValue(ctx, zoneTargetKey{}, target)
+}
+
+// zoneTargetFrom returns the zone ctx updates in multi-zone mode
+func zoneTargetFrom(ctx context.Context) (zoneTarget, bool) {
+	target, ok := ctx.Value(zoneTargetKey{}).(zoneTarget)
+	return target, ok
+}
+
+// currentZone returns the zone name and record names updated with ctx: those of
+// its zone in multi-zone mode, otherwise the configured ones
+func currentZone(ctx context.Context) (string, []string) {
+	if target, ok := zoneTargetFrom(ctx); ok {
+		return target.ZoneName, target.RecordNames
+	}
+	return zoneName, recordNames
+}
+
+// configuredZones reads the config file's zones list, which is empty outside
+// multi-zone mode
+func configuredZones() ([]zoneTarget, error) {
+	var zones []zoneTarget
+	if err := viper.UnmarshalKey("zones", &zones); err != nil {
+		return nil, fmt.Errorf("invalid zones: %w", err)
+	}
+
+	seen := make(map[string]bool, len(zones))
+	for i := range zones {
+		zone := &zones[i]
+		zone.RecordNames = splitRecordNames(zone.RecordNames...)
+		if zone.ZoneName == "" || len(zone.RecordNames) == 0 {
+			return nil, fmt.Errorf("zones[%d] needs zone-name and record-name", i)
+		}
+		if seen[zone.ZoneName] {
+			return nil, fmt.Errorf("zone %s is listed more than once", zone.ZoneName)
+		}
+		seen[zone.ZoneName] = true
+		if zone.APIToken == "" {
+			zone.APIToken = vaultZoneTokens[zone.ZoneName]
+		}
+	}
+	return zones, nil
+}
+
+// loadZoneTokens reads the token of every zone with a vault-path for
+// --token-per-zone, which requires each zone to bring its own token
+func loadZoneTokens() error {
+	zones, err := configuredZones()
+	if err != nil {
+		return err
+	}
+	if len(zones) == 0 {
+		return fmt.Errorf("--token-per-zone needs the config file's zones list")
+	}
+
+	// Tokens are only replaced once every zone's has been read
+	tokens := map[string]string{}
+	for _, zone := range zones {
+		switch {
+		case zone.APIToken != "":
+		case zone.VaultPath != "":
+			token, err := readVaultZoneToken(zone.VaultPath)
+			if err != nil {
+				return fmt.Errorf("zone %s: %w", zone.ZoneName, err)
+			}
+			tokens[zone.ZoneName] = token
+		default:
+			return fmt.Errorf("zone %s needs api-token or vault-path with --token-per-zone", zone.ZoneName)
+		}
+	}
+	vaultZoneTokens = tokens
+	return nil
+}
+
+// updateZones updates every zone concurrently and logs a summary. A failing zone
+// doesn't stop the others; the failures are returned joined.
+func updateZones(ctx context.Context, zones []zoneTarget, ipOverride string) error {
+	errs := make([]error, len(zones))
+	var wg sync.WaitGroup
+	for i, zone := range zones {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			zoneCtx := withZoneTarget(ctx, zone)
+			if err := updateDNS(zoneCtx, ipOverride); err != nil {
+				errs[i] = fmt.Errorf("zone %s: %w", zone.ZoneName, err)
+				notifyUpdateFailed(zoneCtx, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var failed []string
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, zones[i].ZoneName)
+		}
+	}
+	slog.Info("Updated zones", "succeeded", len(zones)-len(failed), "failed", len(failed), "failed_zones", failed)
+	return errors.Join(errs...)
+}
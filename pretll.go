@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/spf13/viper"
+)
+
+// automaticTTLSeconds is how long resolvers may cache a record with automatic TTL
+const automaticTTLSeconds = 300
+
+// lowersTTLBeforeUpdate reports whether --cloudflare-record-ttl-before-update
+// applies to record. Proxied records resolve to Cloudflare's addresses, and a
+// record that was not read first has no known content or TTL to keep meanwhile.
+func lowersTTLBeforeUpdate(record cloudflare.DNSRecord) bool {
+	if !viper.GetBool("cloudflare-record-ttl-before-update") || record.Content == "" || record.TTL == 0 {
+		return false
+	}
+	if record.Proxied != nil && *record.Proxied {
+		return false
+	}
+	return cachedTTL(record) > viper.GetInt("pre-update-ttl")
+}
+
+// cachedTTL returns how long resolvers may cache record, in seconds
+func cachedTTL(record cloudflare.DNSRecord) int {
+	if record.TTL == automaticTTL {
+		return automaticTTLSeconds
+	}
+	return record.TTL
+}
+
+// lowerTTL sets record's TTL to --pre-update-ttl, keeping its content, and waits
+// until resolvers have dropped the copy cached with the old TTL. The returned
+// context replaces ctx for the IP update, whose deadline could not cover the wait.
+func lowerTTL(ctx context.Context, api *cloudflare.API, zone *cloudflare.ResourceContainer, record cloudflare.DNSRecord) (context.Context, context.CancelFunc, error) {
+	ttl := viper.GetInt("pre-update-ttl")
+	if _, err := api.UpdateDNSRecord(ctx, zone, ttlUpdateParams(record, ttl)); err != nil {
+		return nil, nil, fmt.Errorf("error lowering TTL of DNS record %s: %w", record.Name, err)
+	}
+
+	wait := time.Duration(cachedTTL(record)) * time.Second
+	slog.Info("Lowered DNS record TTL, waiting for cached copies to expire before updating it",
+		"record", record.Name, "ttl", ttl, "previous_ttl", record.TTL, "wait", wait)
+	// The wait is not cut short on shutdown, which would leave the record with the
+	// lowered TTL and its old IP. The update then gets the 10 seconds updateDNS allows.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), wait+10*time.Second)
+	sleepContext(ctx, wait)
+	return ctx, cancel, nil
+}
+
+// restoreTTL puts back the TTL lowerTTL replaced, after the IP update failed
+func restoreTTL(ctx context.Context, api *cloudflare.API, zone *cloudflare.ResourceContainer, record cloudflare.DNSRecord) {
+	if _, err := api.UpdateDNSRecord(ctx, zone, ttlUpdateParams(record, record.TTL)); err != nil {
+		slog.Warn("Unable to restore DNS record TTL", "record", record.Name, "ttl", record.TTL, "error", err)
+	}
+}
+
+// ttlUpdateParams returns the update that only changes record's TTL to ttl
+func ttlUpdateParams(record cloudflare.DNSRecord, ttl int) cloudflare.UpdateDNSRecordParams {
+	var proxied *bool
+	if record.Proxied != nil {
+		proxied = boolPtr(*record.Proxied)
+	}
+	return cloudflare.UpdateDNSRecordParams{
+		ID:      record.ID,
+		Type:    record.Type,
+		Name:    record.Name,
+		Content: record.Content,
+		TTL:     ttl,
+		Proxied: proxied,
+		Tags:    record.Tags,
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultRateLimitWindow is Cloudflare's rate limit window (1200 requests per
+// 5 minutes), used when a response does not say when the window resets
+const defaultRateLimitWindow = 5 * time.Minute
+
+// rateLimitState is the rate limit last reported in the CF-RateLimit-* response
+// headers
+type rateLimitState struct {
+	mu        sync.Mutex
+	limit     int
+	remaining int
+	reset     time.Time
+}
+
+// cloudflareRateLimit is shared by every Cloudflare client, so the safety margin
+// holds across tokens and daemon cycles rather than restarting with each client
+var cloudflareRateLimit rateLimitState
+
+// rateLimitTransport tracks the CF-RateLimit-* response headers in state and, once
+// fewer than margin*limit requests remain, holds further requests until the
+// window resets
+type rateLimitTransport struct {
+	margin float64
+	state  *rateLimitState
+	next   http.RoundTripper
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if wait, remaining, limit := t.state.wait(t.margin); wait > 0 {
+		slog.Warn("Cloudflare API rate limit nearly exhausted, sleeping until the window resets", "remaining", remaining, "limit", limit, "sleep", wait.Round(time.Second))
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err == nil {
+		t.state.observe(resp.Header)
+	}
+	return resp, err
+}
+
+// wait returns how long to hold the next request (0 if it can be sent now) along
+// with the last observed remaining and limit counts
+func (s *rateLimitState) wait(margin float64) (time.Duration, int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.limit == 0 || float64(s.remaining) >= margin*float64(s.limit) {
+		return 0, s.remaining, s.limit
+	}
+	return time.Until(s.reset), s.remaining, s.limit
+}
+
+// observe records the rate limit state reported by a response
+func (s *rateLimitState) observe(header http.Header) {
+	limit, err := strconv.Atoi(header.Get("CF-RateLimit-Limit"))
+	if err != nil {
+		return
+	}
+	remaining, err := strconv.Atoi(header.Get("CF-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+
+	window := defaultRateLimitWindow
+	if seconds, err := strconv.Atoi(header.Get("CF-RateLimit-Reset")); err == nil {
+		window = time.Duration(seconds) * time.Second
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limit = limit
+	s.remaining = remaining
+	s.reset = time.Now().Add(window)
+}
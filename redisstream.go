@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
+)
+
+// redisStream holds the client for --redis-stream-url, reused across updates
+var redisStream struct {
+	sync.Mutex
+	url    string
+	client *redis.Client
+}
+
+// redisStreamClient returns a client for --redis-stream-url, or nil when it is
+// not set. The client is replaced when the URL changes on a config reload.
+func redisStreamClient() (*redis.Client, error) {
+	url := viper.GetString("redis-stream-url")
+
+	redisStream.Lock()
+	defer redisStream.Unlock()
+	if redisStream.client != nil && redisStream.url == url {
+		return redisStream.client, nil
+	}
+	if redisStream.client != nil {
+		redisStream.client.Close()
+		redisStream.client = nil
+	}
+	if url == "" {
+		return nil, nil
+	}
+
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --redis-stream-url: %w", err)
+	}
+	redisStream.url, redisStream.client = url, redis.NewClient(opts)
+	return redisStream.client, nil
+}
+
+// publishRecordEvent adds a JSON entry describing what happened to record to
+// --redis-stream-key. action is created, updated or unchanged. A failed publish
+// is logged and otherwise ignored.
+func publishRecordEvent(ctx context.Context, action, record, oldIP, newIP string) {
+	client, err := redisStreamClient()
+	if client == nil {
+		if err != nil {
+			slog.Warn("Unable to publish to Redis stream", "error", err)
+		}
+		return
+	}
+
+	zoneName, _ := currentZone(ctx)
+	data, err := json.Marshal(map[string]string{
+		"action":    action,
+		"record":    record,
+		"zone":      zoneName,
+		"old_ip":    oldIP,
+		"new_ip":    newIP,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		slog.Warn("Unable to publish to Redis stream", "error", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+	key := viper.GetString("redis-stream-key")
+	if err := client.XAdd(ctx, &redis.XAddArgs{Stream: key, Values: map[string]interface{}{"data": data}}).Err(); err != nil {
+		slog.Warn("Unable to publish to Redis stream", "stream", key, "record", record, "error", err)
+	}
+}
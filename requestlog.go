@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// redactedHeaders are request headers that carry credentials
+var redactedHeaders = []string{"Authorization", "X-Auth-Key", "X-Auth-User-Service-Key"}
+
+// requestLogEntry is a single line of --cloudflare-request-log-file
+type requestLogEntry struct {
+	Time            time.Time   `json:"time"`
+	Method          string      `json:"method"`
+	URL             string      `json:"url"`
+	RequestHeaders  http.Header `json:"request_headers"`
+	Status          int         `json:"status,omitempty"`
+	ResponseHeaders http.Header `json:"response_headers,omitempty"`
+	ResponseBody    string      `json:"response_body,omitempty"`
+	Error           string      `json:"error,omitempty"`
+	DurationMS      int64       `json:"duration_ms"`
+}
+
+// requestLogTransport appends every request and its response to path as JSONL.
+// secretHeaders are redacted along with redactedHeaders.
+type requestLogTransport struct {
+	path          string
+	secretHeaders []string
+	next          http.RoundTripper
+}
+
+// requestLogMu serializes writes to the request log, which may be shared by
+// several API clients
+var requestLogMu sync.Mutex
+
+func (t *requestLogTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	entry := requestLogEntry{
+		Time:           time.Now().UTC(),
+		Method:         req.Method,
+		URL:            req.URL.String(),
+		RequestHeaders: req.Header.Clone(),
+	}
+	for _, key := range slices.Concat(redactedHeaders, t.secretHeaders) {
+		if entry.RequestHeaders.Get(key) != "" {
+			entry.RequestHeaders.Set(key, redacted)
+		}
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	entry.DurationMS = time.Since(entry.Time).Milliseconds()
+	if err != nil {
+		entry.Error = err.Error()
+	} else {
+		entry.Status = resp.StatusCode
+		entry.ResponseHeaders = resp.Header
+		body, readErr := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		entry.ResponseBody = string(body)
+		if readErr != nil {
+			entry.Error = readErr.Error()
+		}
+	}
+
+	// A broken request log must not block DNS updates
+	if logErr := t.write(entry); logErr != nil {
+		slog.Warn("Unable to write request log", "error", logErr)
+	}
+	return resp, err
+}
+
+func (t *requestLogTransport) write(entry requestLogEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("error encoding request log entry: %w", err)
+	}
+
+	requestLogMu.Lock()
+	defer requestLogMu.Unlock()
+
+	f, err := os.OpenFile(t.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("error opening request log %s: %w", t.path, err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing request log %s: %w", t.path, err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"context"
+	"net"
+)
+
+// resolvesTo reports whether name currently resolves to ip
+func resolvesTo(ctx context.Context, name, ip string) (bool, error) {
+	addrs, err := net.DefaultResolver.LookupHost(ctx, name)
+	if err != nil {
+		return false, err
+	}
+
+	want := net.ParseIP(ip)
+	for _, addr := range addrs {
+		if net.ParseIP(addr).Equal(want) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Retry policy applied to Cloudflare API requests. These match the defaults the
+// cloudflare-go client uses internally, which is disabled in favour of retryTransport.
+const (
+	cloudflareMaxRetries    = 3
+	cloudflareMinRetryDelay = time.Second
+	cloudflareMaxRetryDelay = 30 * time.Second
+)
+
+// defaultRetryOnCodes are the status codes retried when --cloudflare-retry-on-codes is not set
+var defaultRetryOnCodes = []string{"429", "500", "502", "503", "524"}
+
+// parseStatusCodes parses a list of HTTP error status codes (4xx or 5xx)
+func parseStatusCodes(values []string) (map[int]bool, error) {
+	codes := make(map[int]bool, len(values))
+	for _, value := range values {
+		code, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an HTTP status code", value)
+		}
+		if code < 400 || code > 599 {
+			return nil, fmt.Errorf("%d is not an HTTP error status code (want 400-599)", code)
+		}
+		codes[code] = true
+	}
+	return codes, nil
+}
+
+// retryTransport retries requests that fail at the network level or whose response
+// status is in codes, backing off exponentially between attempts. Each delay is
+// extended by up to jitter times itself so that several instances do not retry in step.
+type retryTransport struct {
+	codes  map[int]bool
+	jitter float64
+	next   http.RoundTripper
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			if req.Body != nil {
+				if req.GetBody == nil {
+					return nil, fmt.Errorf("cannot retry %s %s: request body is not replayable", req.Method, req.URL.Path)
+				}
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				req = req.Clone(req.Context())
+				req.Body = body
+			}
+
+			delay := retryDelay(attempt)
+			delay += time.Duration(t.jitter * float64(delay) * rand.Float64())
+			slog.Warn("Retrying Cloudflare API request", "method", req.Method, "path", req.URL.Path, "delay", delay, "attempt", attempt, "max_retries", cloudflareMaxRetries)
+			select {
+			case <-time.After(delay):
+			case <-req.Context().Done():
+				return nil, req.Context().Err()
+			}
+		}
+
+		resp, err := t.next.RoundTrip(req)
+		if attempt == cloudflareMaxRetries || req.Context().Err() != nil {
+			return resp, err
+		}
+		if err != nil {
+			continue
+		}
+		if !t.codes[resp.StatusCode] {
+			return resp, nil
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+}
+
+// retryDelay returns the backoff before the given retry attempt (starting at 1)
+func retryDelay(attempt int) time.Duration {
+	delay := cloudflareMinRetryDelay << (attempt - 1)
+	if delay > cloudflareMaxRetryDelay {
+		delay = cloudflareMaxRetryDelay
+	}
+	return delay
+}
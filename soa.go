@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// soaTTLCacheDuration is how long a zone's SOA minimum TTL is reused before
+// its nameservers are asked again
+const soaTTLCacheDuration = time.Hour
+
+// soaTTLs caches the SOA minimum TTL of each zone
+var soaTTLs struct {
+	sync.Mutex
+	byZone map[string]cachedSOATTL
+}
+
+type cachedSOATTL struct {
+	ttl     int
+	fetched time.Time
+}
+
+// recordTTL returns the TTL given to records of ctx's zone: --ttl, or the zone's
+// SOA minimum with --record-ttl-sync. 0 keeps the current TTL of existing records.
+func recordTTL(ctx context.Context) int {
+	if ttl := viper.GetInt("ttl"); ttl > 0 || !viper.GetBool("record-ttl-sync") {
+		return ttl
+	}
+
+	zoneName, _ := currentZone(ctx)
+	ttl, err := zoneSOAMinimumTTL(ctx, zoneName)
+	if err != nil {
+		slog.Warn("Unable to read the zone's SOA minimum TTL, keeping the record TTL", "zone", zoneName, "error", err)
+		return 0
+	}
+
+	// Cloudflare rejects TTLs outside the plan's limits
+	return min(max(ttl, viper.GetInt("cloudflare-min-ttl")), maxTTL)
+}
+
+// zoneSOAMinimumTTL returns the minimum TTL of zone's SOA record, cached for
+// soaTTLCacheDuration
+func zoneSOAMinimumTTL(ctx context.Context, zone string) (int, error) {
+	soaTTLs.Lock()
+	cached, ok := soaTTLs.byZone[zone]
+	soaTTLs.Unlock()
+	if ok && time.Since(cached.fetched) < soaTTLCacheDuration {
+		return cached.ttl, nil
+	}
+
+	ttl, err := lookupSOAMinimumTTL(ctx, zone)
+	if err != nil {
+		return 0, err
+	}
+	slog.Debug("Read SOA minimum TTL", "zone", zone, "ttl", ttl)
+
+	soaTTLs.Lock()
+	defer soaTTLs.Unlock()
+	if soaTTLs.byZone == nil {
+		soaTTLs.byZone = map[string]cachedSOATTL{}
+	}
+	soaTTLs.byZone[zone] = cachedSOATTL{ttl: ttl, fetched: time.Now()}
+	return ttl, nil
+}
+
+// lookupSOAMinimumTTL asks zone's authoritative nameservers for its SOA record,
+// returning the answer of the first one that responds
+func lookupSOAMinimumTTL(ctx context.Context, zone string) (int, error) {
+	nameservers, err := net.DefaultResolver.LookupNS(ctx, zone)
+	if err != nil {
+		return 0, fmt.Errorf("error looking up nameservers of %s: %w", zone, err)
+	}
+	if len(nameservers) == 0 {
+		return 0, fmt.Errorf("%s has no nameservers", zone)
+	}
+
+	var lastErr error
+	for _, ns := range nameservers {
+		ttl, err := querySOAMinimumTTL(ctx, ns.Host, zone)
+		if err == nil {
+			return ttl, nil
+		}
+		slog.Debug("SOA query failed", "nameserver", ns.Host, "zone", zone, "error", err)
+		lastErr = err
+	}
+	return 0, fmt.Errorf("no nameserver of %s answered the SOA query: %w", zone, lastErr)
+}
+
+// soaQueryTimeout bounds the wait for each nameserver's answer
+const soaQueryTimeout = 2 * time.Second
+
+// querySOAMinimumTTL sends a single SOA query for zone to nameserver over UDP
+func querySOAMinimumTTL(ctx context.Context, nameserver, zone string) (int, error) {
+	name, err := dnsmessage.NewName(dnsFQDN(zone))
+	if err != nil {
+		return 0, err
+	}
+	var idBytes [2]byte
+	if _, err := rand.Read(idBytes[:]); err != nil {
+		return 0, err
+	}
+	id := binary.BigEndian.Uint16(idBytes[:])
+	query, err := (&dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id},
+		Questions: []dnsmessage.Question{{Name: name, Type: dnsmessage.TypeSOA, Class: dnsmessage.ClassINET}},
+	}).Pack()
+	if err != nil {
+		return 0, err
+	}
+
+	// A dropped packet only costs this nameserver's attempt, leaving the rest of
+	// the update's deadline for the next one
+	ctx, cancel := context.WithTimeout(ctx, soaQueryTimeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", net.JoinHostPort(nameserver, "53"))
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	if _, err := conn.Write(query); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 1232)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return 0, err
+	}
+
+	var msg dnsmessage.Message
+	if err := msg.Unpack(buf[:n]); err != nil {
+		return 0, fmt.Errorf("invalid DNS response: %w", err)
+	}
+	if msg.ID != id {
+		return 0, fmt.Errorf("DNS response ID %d does not match query %d", msg.ID, id)
+	}
+	if msg.RCode != dnsmessage.RCodeSuccess {
+		return 0, fmt.Errorf("SOA query returned %s", msg.RCode)
+	}
+	for _, answer := range msg.Answers {
+		if soa, ok := answer.Body.(*dnsmessage.SOAResource); ok {
+			return int(soa.MinTTL), nil
+		}
+	}
+	return 0, fmt.Errorf("no SOA record in the response")
+}
+
+// dnsFQDN returns name with the trailing dot of a fully qualified domain name
+func dnsFQDN(name string) string {
+	if len(name) > 0 && name[len(name)-1] == '.' {
+		return name
+	}
+	return name + "."
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The --state-file holds one "<record type> <ip>" line for every address published,
+// so that a restarted process skips the Cloudflare round-trip when the IP is unchanged
+
+// loadStateFile seeds publishedIPs from path. A missing file is not an error.
+func loadStateFile(path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error opening state file: %w", err)
+	}
+	defer f.Close()
+
+	ips := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return fmt.Errorf("%s:%d: want \"<record type> <ip>\"", path, line)
+		}
+		ip, err := parseIP(fields[1])
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		ips[fields[0]] = ip
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading state file: %w", err)
+	}
+
+	publishedIPs.Lock()
+	defer publishedIPs.Unlock()
+	for recordType, ip := range ips {
+		publishedIPs.byType[recordType] = ip
+		slog.Debug("Loaded last published IP from state file", "type", recordType, "ip", ip)
+	}
+	return nil
+}
+
+// saveStateFile writes publishedIPs to path through a temporary file renamed into
+// place, so a crash never leaves a partially written state file behind
+func saveStateFile(path string) error {
+	publishedIPs.Lock()
+	types := make([]string, 0, len(publishedIPs.byType))
+	for recordType := range publishedIPs.byType {
+		types = append(types, recordType)
+	}
+	sort.Strings(types)
+	var b strings.Builder
+	for _, recordType := range types {
+		fmt.Fprintf(&b, "%s %s\n", recordType, publishedIPs.byType[recordType])
+	}
+	publishedIPs.Unlock()
+
+	if err := writeFileAtomic(path, []byte(b.String())); err != nil {
+		return fmt.Errorf("error writing state file: %w", err)
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to path through a temporary file, readable only by
+// the owner, that is renamed into place
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
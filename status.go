@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// printStatus prints the current state of the configured DNS records, including
+// any metadata stored in their comments by --cloudflare-comment-encoding
+func printStatus(ctx context.Context, w io.Writer) error {
+	api, err := newCloudflareAPI(readToken(ctx))
+	if err != nil {
+		return fmt.Errorf("error initializing Cloudflare API: %w", err)
+	}
+	zoneName, recordNames := currentZone(ctx)
+	zoneID, err := lookupZoneID(api, zoneName)
+	if err != nil {
+		return fmt.Errorf("error fetching zone ID: %w", err)
+	}
+
+	var records []cloudflare.DNSRecord
+	for _, name := range recordNames {
+		found, _, err := listDNSRecords(ctx, api, cloudflare.ZoneIdentifier(zoneID), cloudflare.ListDNSRecordsParams{Name: name})
+		if err != nil {
+			return fmt.Errorf("error fetching DNS records: %w", err)
+		}
+		if len(found) == 0 {
+			return fmt.Errorf("no DNS records found for %s", name)
+		}
+		records = append(records, found...)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for i, record := range records {
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+		fmt.Fprintf(tw, "record\t%s %s\n", record.Type, record.Name)
+		fmt.Fprintf(tw, "content\t%s\n", record.Content)
+		fmt.Fprintf(tw, "ttl\t%d\n", record.TTL)
+		fmt.Fprintf(tw, "proxied\t%t\n", record.Proxied != nil && *record.Proxied)
+		fmt.Fprintf(tw, "modified\t%s\n", record.ModifiedOn.Format("2006-01-02 15:04:05 MST"))
+
+		if record.Comment == "" {
+			continue
+		}
+		metadata, err := decodeRecordComment(record.Comment)
+		if err != nil {
+			fmt.Fprintf(tw, "comment\t%s\n", record.Comment)
+			continue
+		}
+		fmt.Fprintf(tw, "updated by\t%s %s\n", metadata.Tool, metadata.Version)
+		fmt.Fprintf(tw, "last ip\t%s\n", metadata.LastIP)
+		fmt.Fprintf(tw, "last updated\t%s\n", metadata.LastUpdated.Format("2006-01-02 15:04:05 MST"))
+	}
+	return tw.Flush()
+}
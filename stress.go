@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// stressResult is the outcome of a single stress-test request
+type stressResult struct {
+	ip       string
+	err      error
+	duration time.Duration
+}
+
+// stressTest sends requests to every IP service, concurrency at a time, and reports
+// latency percentiles, success rate and any IPs that differ from the first result
+func stressTest(ctx context.Context, w io.Writer, requests, concurrency int) error {
+	if requests < 1 || concurrency < 1 {
+		return fmt.Errorf("--requests and --concurrency must be at least 1")
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERVICE\tSUCCESS\tMEDIAN\tP95\tP99\tDIFFERING IPS")
+
+	var firstIP string
+	for _, service := range httpIPServices() {
+		results := benchmarkService(ctx, service, requests, concurrency)
+
+		var durations []time.Duration
+		differing := map[string]bool{}
+		for _, res := range results {
+			if res.err != nil {
+				continue
+			}
+			durations = append(durations, res.duration)
+			if firstIP == "" {
+				firstIP = res.ip
+			}
+			if res.ip != firstIP {
+				differing[res.ip] = true
+			}
+		}
+		sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+
+		var ips []string
+		for ip := range differing {
+			ips = append(ips, ip)
+		}
+		sort.Strings(ips)
+
+		fmt.Fprintf(tw, "%s\t%.1f%%\t%s\t%s\t%s\t%v\n",
+			service,
+			100*float64(len(durations))/float64(len(results)),
+			percentile(durations, 0.50),
+			percentile(durations, 0.95),
+			percentile(durations, 0.99),
+			ips,
+		)
+	}
+
+	return tw.Flush()
+}
+
+// benchmarkService sends requests to service using concurrency workers
+func benchmarkService(ctx context.Context, service string, requests, concurrency int) []stressResult {
+	results := make([]stressResult, requests)
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				start := time.Now()
+				ip, err := fetchIP(ctx, service)
+				results[i] = stressResult{ip: ip, err: err, duration: time.Since(start)}
+			}
+		}()
+	}
+
+	for i := range requests {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+// percentile returns the p-th percentile of the sorted durations, or 0 when empty
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(float64(len(sorted)-1) * p)
+	return sorted[idx].Round(time.Millisecond)
+}
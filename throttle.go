@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/spf13/viper"
+	"golang.org/x/time/rate"
+)
+
+// apiThrottle is shared by every Cloudflare client, so the rate covers the whole
+// process rather than each token's client
+var apiThrottle struct {
+	sync.Mutex
+	limiter *rate.Limiter
+}
+
+// cloudflareThrottle returns the limiter for --cloudflare-api-throttle and
+// --cloudflare-api-throttle-burst, adjusted to the current settings
+func cloudflareThrottle() *rate.Limiter {
+	limit := rate.Limit(viper.GetFloat64("cloudflare-api-throttle"))
+	if limit <= 0 {
+		limit = rate.Inf
+	}
+	burst := viper.GetInt("cloudflare-api-throttle-burst")
+
+	apiThrottle.Lock()
+	defer apiThrottle.Unlock()
+	if apiThrottle.limiter == nil {
+		apiThrottle.limiter = rate.NewLimiter(limit, burst)
+	} else if apiThrottle.limiter.Limit() != limit || apiThrottle.limiter.Burst() != burst {
+		apiThrottle.limiter.SetLimit(limit)
+		apiThrottle.limiter.SetBurst(burst)
+	}
+	return apiThrottle.limiter
+}
+
+// throttleTransport holds each request until limiter admits it. Short bursts of
+// calls, such as the zone lookup, listing and update of one cycle, go out at
+// once while the sustained rate stays within the limit.
+type throttleTransport struct {
+	limiter *rate.Limiter
+	next    http.RoundTripper
+}
+
+func (t *throttleTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+	return t.next.RoundTrip(req)
+}
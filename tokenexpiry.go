@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/spf13/viper"
+)
+
+// extendTokenExpiries extends every configured API token that expires within
+// --cloudflare-token-rotate-before-expiry by --cloudflare-token-extension-days.
+// Updating a token needs the API Tokens Write permission.
+func extendTokenExpiries(ctx context.Context) error {
+	tokens := []string{writeToken(ctx)}
+	if readToken(ctx) != writeToken(ctx) {
+		tokens = append(tokens, readToken(ctx))
+	}
+
+	for _, token := range tokens {
+		// Multi-zone mode needs no global token when every zone has its own
+		if token == "" {
+			continue
+		}
+		if err := extendTokenExpiry(ctx, token); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extendTokenExpiry(ctx context.Context, value string) error {
+	api, err := newCloudflareAPI(value)
+	if err != nil {
+		return fmt.Errorf("error initializing Cloudflare API: %w", err)
+	}
+	verified, err := api.VerifyAPIToken(ctx)
+	if err != nil {
+		return fmt.Errorf("error verifying API token: %w", err)
+	}
+	token, err := api.GetAPIToken(ctx, verified.ID)
+	if err != nil {
+		return fmt.Errorf("error reading API token %s (the token needs API Tokens Read): %w", verified.ID, err)
+	}
+
+	threshold := viper.GetDuration("cloudflare-token-rotate-before-expiry")
+	if token.ExpiresOn == nil || time.Until(*token.ExpiresOn) > threshold {
+		slog.Debug("API token expiry is not within the rotation threshold", "token", token.Name, "expires", token.ExpiresOn, "threshold", threshold)
+		return nil
+	}
+
+	oldExpiry := *token.ExpiresOn
+	newExpiry := oldExpiry.AddDate(0, 0, viper.GetInt("cloudflare-token-extension-days")).UTC()
+	// The update replaces the whole token, so it is sent back as read with only the
+	// expiry changed
+	update := cloudflare.APIToken{
+		Name:      token.Name,
+		Status:    token.Status,
+		NotBefore: token.NotBefore,
+		ExpiresOn: &newExpiry,
+		Policies:  token.Policies,
+		Condition: token.Condition,
+	}
+	updated, err := api.UpdateAPIToken(ctx, token.ID, update)
+	if err != nil {
+		return fmt.Errorf("error extending API token %s (the token needs API Tokens Write): %w", token.Name, err)
+	}
+	if updated.ExpiresOn != nil {
+		newExpiry = *updated.ExpiresOn
+	}
+
+	slog.Info("Extended API token expiry", "token", token.Name,
+		"old_expiry", oldExpiry.Format(time.RFC3339), "new_expiry", newExpiry.Format(time.RFC3339))
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+)
+
+// tokenReloadTransport re-reads the API token from Vault when Cloudflare answers
+// 401, on the assumption that Vault rotated it, and retries the request once with
+// the new token. Later requests from the same client also use the new token.
+type tokenReloadTransport struct {
+	next http.RoundTripper
+
+	// token replaces the client's token once it has been reloaded
+	mu    sync.Mutex
+	token string
+}
+
+func (t *tokenReloadTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	token := t.token
+	t.mu.Unlock()
+	if token != "" {
+		req = withBearerToken(req, token)
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token != token {
+		// Another request already reloaded the token
+		return t.retryWithToken(req, resp, t.token)
+	}
+
+	slog.Warn("Cloudflare API returned 401, re-reading the API token from Vault", "method", req.Method, "path", req.URL.Path)
+	// readVaultSecret rather than retrieveVaultSecret, whose cache fallback holds the rejected token
+	token, _, _, err = readVaultSecret(req.Context())
+	if err != nil {
+		slog.Warn("Unable to re-read the API token from Vault, not retrying", "error", err)
+		return resp, nil
+	}
+	if token == "" || "Bearer "+token == req.Header.Get("Authorization") {
+		slog.Warn("Vault returned the same API token, not retrying")
+		return resp, nil
+	}
+	setAPIToken(token)
+	t.token = token
+	return t.retryWithToken(req, resp, token)
+}
+
+// retryWithToken discards the rejected resp and sends req again with token
+func (t *tokenReloadTransport) retryWithToken(req *http.Request, resp *http.Response, token string) (*http.Response, error) {
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	retry := withBearerToken(req, token)
+	if req.Body != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		retry.Body = body
+	}
+	return t.next.RoundTrip(retry)
+}
+
+// withBearerToken returns a copy of req authenticated with token
+func withBearerToken(req *http.Request, token string) *http.Request {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/spf13/viper"
+)
+
+// tokenPermission identifies a permission group by its legacy key and display name
+type tokenPermission struct {
+	key  string
+	name string
+}
+
+func (p tokenPermission) matches(group cloudflare.APITokenPermissionGroups) bool {
+	return group.Name == p.key || group.Name == p.name
+}
+
+// requiredTokenPermissions are the permissions needed to read the zone and edit records
+var requiredTokenPermissions = []tokenPermission{
+	{key: "#dns_records:edit", name: "DNS Write"},
+	{key: "#zone:read", name: "Zone Read"},
+}
+
+// readTokenPermissions and writeTokenPermissions are needed by separate
+// --cloudflare-read-token and --cloudflare-write-token tokens
+var (
+	readTokenPermissions = []tokenPermission{
+		{key: "#zone:read", name: "Zone Read"},
+		{key: "#dns_records:read", name: "DNS Read"},
+	}
+	writeTokenPermissions = []tokenPermission{
+		{key: "#dns_records:edit", name: "DNS Write"},
+	}
+)
+
+// forbiddenTokenPermissions are permissions far broader than a DDNS client needs
+var forbiddenTokenPermissions = []tokenPermission{
+	{key: "#organization:edit", name: "Account Settings Write"},
+}
+
+// errPoliciesUnreadable is returned by tokenPermissionGroups for an active token
+// that lacks API Tokens Read, which the minimal DDNS token does not have
+var errPoliciesUnreadable = errors.New("API token policies are unreadable")
+
+// tokenPermissionGroups verifies the API token and returns its allowed permission groups
+func tokenPermissionGroups(ctx context.Context, api *cloudflare.API) (cloudflare.APIToken, []cloudflare.APITokenPermissionGroups, error) {
+	verified, err := api.VerifyAPIToken(ctx)
+	if err != nil {
+		return cloudflare.APIToken{}, nil, fmt.Errorf("error verifying API token: %w", err)
+	}
+	if verified.Status != "active" {
+		return cloudflare.APIToken{}, nil, fmt.Errorf("API token is %s", verified.Status)
+	}
+
+	token, err := api.GetAPIToken(ctx, verified.ID)
+	if err != nil {
+		return cloudflare.APIToken{}, nil, fmt.Errorf("%w (the token needs API Tokens Read): %w", errPoliciesUnreadable, err)
+	}
+
+	var groups []cloudflare.APITokenPermissionGroups
+	for _, policy := range token.Policies {
+		if policy.Effect == "allow" {
+			groups = append(groups, policy.PermissionGroups...)
+		}
+	}
+	return token, groups, nil
+}
+
+// checkCredentialPermissions checks the API token, or the read and write tokens
+// against their own requirements when they differ
+func checkCredentialPermissions(ctx context.Context) error {
+	type check struct {
+		label    string
+		token    string
+		required []tokenPermission
+	}
+	checks := []check{{"API token", writeToken(ctx), requiredTokenPermissions}}
+	if readToken(ctx) != writeToken(ctx) {
+		checks = []check{
+			{"read token", readToken(ctx), readTokenPermissions},
+			{"write token", writeToken(ctx), writeTokenPermissions},
+		}
+	}
+
+	for _, c := range checks {
+		// Multi-zone mode needs no global token when every zone has its own
+		if c.token == "" {
+			continue
+		}
+		api, err := newCloudflareAPI(c.token)
+		if err != nil {
+			return fmt.Errorf("error initializing Cloudflare API: %w", err)
+		}
+		if err := checkTokenPermissions(ctx, api, c.required); err != nil {
+			return fmt.Errorf("%s: %w", c.label, err)
+		}
+	}
+	return nil
+}
+
+// checkZoneTokens verifies the token of every zone in --token-per-zone mode before
+// any update: each must be active and, unless --skip-permission-check is set,
+// grant DNS Write on its own zone
+func checkZoneTokens(ctx context.Context) error {
+	zones, err := configuredZones()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, zone := range zones {
+		if err := checkZoneToken(ctx, zone); err != nil {
+			errs = append(errs, fmt.Errorf("zone %s: %w", zone.ZoneName, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func checkZoneToken(ctx context.Context, zone zoneTarget) error {
+	api, err := newCloudflareAPI(zone.APIToken)
+	if err != nil {
+		return fmt.Errorf("error initializing Cloudflare API: %w", err)
+	}
+	if viper.GetBool("skip-permission-check") {
+		verified, err := api.VerifyAPIToken(ctx)
+		if err != nil {
+			return fmt.Errorf("error verifying API token: %w", err)
+		}
+		if verified.Status != "active" {
+			return fmt.Errorf("API token is %s", verified.Status)
+		}
+		return nil
+	}
+
+	token, _, err := tokenPermissionGroups(ctx, api)
+	if errors.Is(err, errPoliciesUnreadable) {
+		slog.Warn("Unable to check the zone API token's permissions; it is active", "zone", zone.ZoneName, "error", err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	zoneID, err := lookupZoneID(api, zone.ZoneName)
+	if err != nil {
+		return fmt.Errorf("error fetching Zone ID: %w", err)
+	}
+
+	dnsWrite := writeTokenPermissions[0]
+	for _, policy := range token.Policies {
+		if policy.Effect != "allow" {
+			continue
+		}
+		zoneIDs, allZones := tokenZones(policy.Resources)
+		if !allZones && !slices.Contains(zoneIDs, zoneID) {
+			continue
+		}
+		for _, group := range policy.PermissionGroups {
+			if dnsWrite.matches(group) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("API token %s does not grant %s (%s) on the zone", token.Name, dnsWrite.name, strings.TrimPrefix(dnsWrite.key, "#"))
+}
+
+// checkTokenPermissions returns an error listing any missing required permissions
+// or any overly broad permission granted to the API token. Without API Tokens Read
+// the policies cannot be listed, and only the token's status is checked.
+func checkTokenPermissions(ctx context.Context, api *cloudflare.API, required []tokenPermission) error {
+	_, groups, err := tokenPermissionGroups(ctx, api)
+	if errors.Is(err, errPoliciesUnreadable) {
+		slog.Warn("Unable to check the API token's permissions; it is active", "error", err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var missing, broad []string
+	for _, permission := range required {
+		if !hasPermission(groups, permission) {
+			missing = append(missing, permission.key)
+		}
+	}
+	for _, forbidden := range forbiddenTokenPermissions {
+		if hasPermission(groups, forbidden) {
+			broad = append(broad, forbidden.key)
+		}
+	}
+
+	switch {
+	case len(missing) > 0:
+		return fmt.Errorf("API token is missing required permissions: %s", strings.Join(missing, ", "))
+	case len(broad) > 0:
+		return fmt.Errorf("API token has overly broad permissions: %s", strings.Join(broad, ", "))
+	}
+	return nil
+}
+
+func hasPermission(groups []cloudflare.APITokenPermissionGroups, permission tokenPermission) bool {
+	for _, group := range groups {
+		if permission.matches(group) {
+			return true
+		}
+	}
+	return false
+}
+
+// listTokens prints every API token visible to the configured token
+func listTokens(ctx context.Context, w io.Writer) error {
+	api, err := newCloudflareAPI(globalAPIToken())
+	if err != nil {
+		return fmt.Errorf("error initializing Cloudflare API: %w", err)
+	}
+
+	tokens, err := api.APITokens(ctx)
+	if err != nil {
+		return fmt.Errorf("error listing API tokens: %w", err)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSTATUS\tEXPIRES\tPERMISSIONS")
+	for _, token := range tokens {
+		expires := "never"
+		if token.ExpiresOn != nil {
+			expires = token.ExpiresOn.Format(time.RFC3339)
+		}
+
+		var permissions []string
+		for _, policy := range token.Policies {
+			for _, group := range policy.PermissionGroups {
+				permissions = append(permissions, group.Name)
+			}
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", token.Name, token.Status, expires, strings.Join(permissions, ", "))
+	}
+	return tw.Flush()
+}
+
+// errBroadToken is returned by verifyToken when the token grants more than this
+// client needs
+var errBroadToken = errors.New("API token permissions are broader than needed")
+
+// zoneResourcePrefix prefixes zone resources in token policies; ".*" selects every zone
+const zoneResourcePrefix = "com.cloudflare.api.account.zone."
+
+// verifyToken prints the permission groups and zones granted by the API token and
+// reports permissions missing or beyond the minimum required
+func verifyToken(ctx context.Context, w io.Writer) error {
+	api, err := newCloudflareAPI(globalAPIToken())
+	if err != nil {
+		return fmt.Errorf("error initializing Cloudflare API: %w", err)
+	}
+
+	token, groups, err := tokenPermissionGroups(ctx, api)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Token:\t%s (%s)\n", token.Name, token.ID)
+	fmt.Fprintf(tw, "Status:\t%s\n\n", token.Status)
+	fmt.Fprintln(tw, "EFFECT\tPERMISSION\tZONES")
+
+	var broad []string
+	for _, policy := range token.Policies {
+		zones, allZones := tokenZones(policy.Resources)
+		for _, group := range policy.PermissionGroups {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", policy.Effect, group.Name, strings.Join(zones, ", "))
+		}
+		if policy.Effect != "allow" {
+			continue
+		}
+		if allZones {
+			broad = append(broad, "access to all zones")
+		}
+		for _, group := range policy.PermissionGroups {
+			if !isRequiredPermission(group) {
+				broad = append(broad, group.Name)
+			}
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	var missing []string
+	for _, required := range requiredTokenPermissions {
+		if !hasPermission(groups, required) {
+			missing = append(missing, required.name)
+		}
+	}
+
+	fmt.Fprintln(w)
+	switch {
+	case len(missing) > 0:
+		return fmt.Errorf("API token is missing required permissions: %s", strings.Join(missing, ", "))
+	case len(broad) > 0:
+		for _, permission := range broad {
+			fmt.Fprintf(w, "BROADER THAN NEEDED  %s\n", permission)
+		}
+		return errBroadToken
+	}
+	fmt.Fprintln(w, "API token grants exactly the required permissions")
+	return nil
+}
+
+func isRequiredPermission(group cloudflare.APITokenPermissionGroups) bool {
+	for _, required := range requiredTokenPermissions {
+		if required.matches(group) {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenZones describes the zones covered by a policy's resources and reports
+// whether they include every zone of an account
+func tokenZones(resources map[string]interface{}) ([]string, bool) {
+	var zones []string
+	allZones := false
+	for key, value := range resources {
+		switch {
+		case key == zoneResourcePrefix+"*":
+			zones = append(zones, "all zones")
+			allZones = true
+		case strings.HasPrefix(key, zoneResourcePrefix):
+			zones = append(zones, strings.TrimPrefix(key, zoneResourcePrefix))
+		default:
+			// Account resources either hold nested zone resources or cover the whole account
+			nested, ok := value.(map[string]interface{})
+			if !ok {
+				zones = append(zones, "all zones in "+key)
+				allZones = true
+				continue
+			}
+			inner, all := tokenZones(nested)
+			zones = append(zones, inner...)
+			allZones = allZones || all
+		}
+	}
+	sort.Strings(zones)
+	return zones, allZones
+}
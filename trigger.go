@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/nats-io/nats.go"
+	"github.com/spf13/viper"
+)
+
+// updateTrigger requests an immediate update cycle. When ip is set it is published
+// instead of the detected IP.
+type updateTrigger struct {
+	ip string
+}
+
+// subscribeNATSTrigger sends a trigger for every message received on
+// --trigger-nats-subject. Messages may carry a JSON payload {"ip": "..."} to
+// override the detected IP. The returned function closes the connection.
+func subscribeNATSTrigger(triggers chan<- updateTrigger) (func(), error) {
+	url := viper.GetString("trigger-nats-url")
+	subject := viper.GetString("trigger-nats-subject")
+
+	nc, err := nats.Connect(url, nats.Name("caddy-ddns"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to NATS at %s: %w", url, err)
+	}
+
+	_, err = nc.Subscribe(subject, func(msg *nats.Msg) {
+		var payload struct {
+			IP string `json:"ip"`
+		}
+		if data := bytes.TrimSpace(msg.Data); len(data) > 0 {
+			if err := json.Unmarshal(data, &payload); err != nil {
+				slog.Warn("Ignoring malformed trigger payload", "subject", subject, "error", err)
+				return
+			}
+		}
+		if payload.IP != "" {
+			ip, err := parseIP(payload.IP)
+			if err != nil {
+				slog.Warn("Ignoring trigger with invalid IP override", "subject", subject, "error", err)
+				return
+			}
+			payload.IP = ip
+		}
+
+		slog.Info("Update triggered by message", "subject", subject)
+		triggers <- updateTrigger{ip: payload.IP}
+	})
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("unable to subscribe to NATS subject %s: %w", subject, err)
+	}
+
+	return nc.Close, nil
+}
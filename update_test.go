@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// mockCloudflare is an in-memory stand-in for the zone and DNS record endpoints
+// of the Cloudflare API
+type mockCloudflare struct {
+	mu      sync.Mutex
+	zone    cloudflare.Zone
+	records []cloudflare.DNSRecord
+	lists   int
+	patches []string // paths of PATCH requests
+	updates []cloudflare.DNSRecord
+	creates []cloudflare.DNSRecord
+}
+
+// newMockCloudflare serves the mock over TLS and routes the Cloudflare client to it
+// for the duration of the test
+func newMockCloudflare(t *testing.T, zone string, records ...cloudflare.DNSRecord) *mockCloudflare {
+	t.Helper()
+	m := &mockCloudflare{zone: cloudflare.Zone{ID: "zone-id", Name: zone}, records: records}
+
+	srv := httptest.NewTLSServer(m)
+	t.Cleanup(srv.Close)
+
+	previous := cloudflareTransport
+	cloudflareTransport = srv.Client().Transport
+	t.Cleanup(func() { cloudflareTransport = previous })
+	// The mock has no rate limit to stay under
+	setConfig(t, map[string]interface{}{"cloudflare-api-url": srv.URL, "cloudflare-api-throttle": 0})
+	return m
+}
+
+func (m *mockCloudflare) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	recordsPath := "/zones/" + m.zone.ID + "/dns_records"
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/zones":
+		var zones []cloudflare.Zone
+		if r.URL.Query().Get("name") == m.zone.Name {
+			zones = append(zones, m.zone)
+		}
+		writeResult(w, zones, len(zones))
+
+	case r.Method == http.MethodGet && r.URL.Path == recordsPath:
+		m.lists++
+		var matched []cloudflare.DNSRecord
+		query := r.URL.Query()
+		for _, record := range m.records {
+			proxied := record.Proxied != nil && *record.Proxied
+			if (query.Get("name") == "" || query.Get("name") == record.Name) &&
+				(query.Get("type") == "" || query.Get("type") == record.Type) &&
+				(query.Get("proxied") == "" || query.Get("proxied") == fmt.Sprint(proxied)) {
+				matched = append(matched, record)
+			}
+		}
+		writeResult(w, matched, len(matched))
+
+	case r.Method == http.MethodPost && r.URL.Path == recordsPath:
+		var record cloudflare.DNSRecord
+		if err := json.NewDecoder(r.Body).Decode(&record); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		record.ID = fmt.Sprintf("created-%d", len(m.creates)+1)
+		m.creates = append(m.creates, record)
+		m.records = append(m.records, record)
+		writeResult(w, record, 1)
+
+	case r.Method == http.MethodPost && r.URL.Path == recordsPath+"/batch":
+		var batch struct {
+			Patches []cloudflare.DNSRecord `json:"patches"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, patch := range batch.Patches {
+			for i := range m.records {
+				if m.records[i].ID == patch.ID {
+					m.records[i].Content = patch.Content
+					m.updates = append(m.updates, m.records[i])
+				}
+			}
+		}
+		writeResult(w, batch, len(batch.Patches))
+
+	case r.Method == http.MethodPatch && strings.HasPrefix(r.URL.Path, recordsPath+"/"):
+		m.patches = append(m.patches, r.URL.Path)
+		id := strings.TrimPrefix(r.URL.Path, recordsPath+"/")
+		for i := range m.records {
+			if m.records[i].ID != id {
+				continue
+			}
+			if err := json.NewDecoder(r.Body).Decode(&m.records[i]); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			m.updates = append(m.updates, m.records[i])
+			writeResult(w, m.records[i], 1)
+			return
+		}
+		http.NotFound(w, r)
+
+	default:
+		http.Error(w, "unexpected request "+r.Method+" "+r.URL.String(), http.StatusNotImplemented)
+	}
+}
+
+// writeResult writes a successful, single-page Cloudflare API response
+func writeResult(w http.ResponseWriter, result interface{}, count int) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"errors":   []interface{}{},
+		"messages": []interface{}{},
+		"result":   result,
+		"result_info": map[string]int{
+			"page":        1,
+			"per_page":    100,
+			"count":       count,
+			"total_count": count,
+			"total_pages": 1,
+		},
+	})
+}
+
+// newIPService serves ip as the public IP and makes it the only --ip-services entry
+func newIPService(t *testing.T, ip string) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, ip)
+	}))
+	t.Cleanup(srv.Close)
+	setConfig(t, map[string]interface{}{"ip-services": []string{srv.URL}})
+}
+
+func TestUpdateDNS(t *testing.T) {
+	const (
+		zone   = "example.com"
+		record = "home.example.com"
+	)
+
+	tests := []struct {
+		name        string
+		existing    []cloudflare.DNSRecord
+		publicIP    string
+		wantUpdates int
+		wantCreates int
+		wantContent string
+	}{
+		{
+			name:        "IP matches existing record",
+			existing:    []cloudflare.DNSRecord{{ID: "record-id", Type: "A", Name: record, Content: "203.0.113.7", TTL: 300}},
+			publicIP:    "203.0.113.7",
+			wantContent: "203.0.113.7",
+		},
+		{
+			name:        "IP differs from existing record",
+			existing:    []cloudflare.DNSRecord{{ID: "record-id", Type: "A", Name: record, Content: "198.51.100.1", TTL: 300}},
+			publicIP:    "203.0.113.7",
+			wantUpdates: 1,
+			wantContent: "203.0.113.7",
+		},
+		{
+			name:        "no existing record",
+			publicIP:    "203.0.113.7",
+			wantCreates: 1,
+			wantContent: "203.0.113.7",
+		},
+		{
+			name:        "other record types are left alone",
+			existing:    []cloudflare.DNSRecord{{ID: "txt-id", Type: "TXT", Name: record, Content: "hello"}},
+			publicIP:    "203.0.113.7",
+			wantCreates: 1,
+			wantContent: "203.0.113.7",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setCredentials(t, "test-token", zone, record)
+			newIPService(t, tt.publicIP)
+			cf := newMockCloudflare(t, zone, tt.existing...)
+
+			if err := updateDNS(context.Background(), ""); err != nil {
+				t.Fatalf("updateDNS() error = %v", err)
+			}
+
+			if len(cf.updates) != tt.wantUpdates {
+				t.Errorf("got %d updates, want %d", len(cf.updates), tt.wantUpdates)
+			}
+			if len(cf.creates) != tt.wantCreates {
+				t.Errorf("got %d creates, want %d", len(cf.creates), tt.wantCreates)
+			}
+			for _, r := range cf.records {
+				if r.Type == "A" && r.Name == record && r.Content != tt.wantContent {
+					t.Errorf("record content = %q, want %q", r.Content, tt.wantContent)
+				}
+			}
+		})
+	}
+}
+
+func TestUpdateDNSUnknownZone(t *testing.T) {
+	setCredentials(t, "test-token", "missing.example", "home.missing.example")
+	newIPService(t, "203.0.113.7")
+	newMockCloudflare(t, "example.com")
+
+	if err := updateDNS(context.Background(), ""); err == nil {
+		t.Fatal("updateDNS() succeeded for a zone that does not exist")
+	}
+}
+
+// TestUpdateDNSRecordID guards against updates that don't carry the ID of the
+// listed record, which Cloudflare rejects
+func TestUpdateDNSRecordID(t *testing.T) {
+	setCredentials(t, "test-token", "example.com", "home.example.com")
+	newIPService(t, "203.0.113.7")
+	cf := newMockCloudflare(t, "example.com",
+		cloudflare.DNSRecord{ID: "other-id", Type: "A", Name: "vpn.example.com", Content: "198.51.100.1"},
+		cloudflare.DNSRecord{ID: "372e67954025e0ba6aaa6d586b9e0b59", Type: "A", Name: "home.example.com", Content: "198.51.100.1"})
+
+	if err := updateDNS(context.Background(), ""); err != nil {
+		t.Fatalf("updateDNS() error = %v", err)
+	}
+	want := []string{"/zones/zone-id/dns_records/372e67954025e0ba6aaa6d586b9e0b59"}
+	if !slices.Equal(cf.patches, want) {
+		t.Errorf("PATCH requests = %q, want %q", cf.patches, want)
+	}
+}
+
+func TestUpdateDNSProxiedFilter(t *testing.T) {
+	setCredentials(t, "test-token", "example.com", "home.example.com")
+	newIPService(t, "203.0.113.7")
+	cf := newMockCloudflare(t, "example.com",
+		cloudflare.DNSRecord{ID: "unproxied-id", Type: "A", Name: "home.example.com", Content: "198.51.100.1", Proxied: boolPtr(false)},
+		cloudflare.DNSRecord{ID: "proxied-id", Type: "A", Name: "home.example.com", Content: "198.51.100.1", Proxied: boolPtr(true)})
+	setConfig(t, map[string]interface{}{"proxied": true})
+
+	if err := updateDNS(context.Background(), ""); err != nil {
+		t.Fatalf("updateDNS() error = %v", err)
+	}
+	want := []string{"/zones/zone-id/dns_records/proxied-id"}
+	if !slices.Equal(cf.patches, want) {
+		t.Errorf("PATCH requests = %q, want %q", cf.patches, want)
+	}
+	if len(cf.updates) != 1 || cf.updates[0].Proxied == nil || !*cf.updates[0].Proxied {
+		t.Errorf("updates = %+v, want one that keeps the record proxied", cf.updates)
+	}
+}
+
+// TestUpdateDNSProxiedChange checks that a record updated without reading it
+// first is sent with the --proxied state rather than its current one
+func TestUpdateDNSProxiedChange(t *testing.T) {
+	setCredentials(t, "test-token", "example.com", "home.example.com")
+	newIPService(t, "203.0.113.7")
+	cf := newMockCloudflare(t, "example.com",
+		cloudflare.DNSRecord{ID: "record-id", Type: "A", Name: "home.example.com", Content: "198.51.100.1", Proxied: boolPtr(false)})
+	setConfig(t, map[string]interface{}{"skip-prefetch": true, "record-id": "record-id", "proxied": true})
+
+	if err := updateDNS(context.Background(), ""); err != nil {
+		t.Fatalf("updateDNS() error = %v", err)
+	}
+	if len(cf.updates) != 1 || cf.updates[0].Proxied == nil || !*cf.updates[0].Proxied {
+		t.Errorf("updates = %+v, want one that proxies the record", cf.updates)
+	}
+}
+
+// TestUpdateDNSBatchWithoutProxied covers batch updates of records listed without
+// a proxied field
+func TestUpdateDNSBatchWithoutProxied(t *testing.T) {
+	setCredentials(t, "test-token", "example.com", "home.example.com")
+	newIPService(t, "203.0.113.7")
+	cf := newMockCloudflare(t, "example.com",
+		cloudflare.DNSRecord{ID: "home-id", Type: "A", Name: "home.example.com", Content: "198.51.100.1"},
+		cloudflare.DNSRecord{ID: "vpn-id", Type: "A", Name: "vpn.example.com", Content: "198.51.100.1"})
+	setConfig(t, map[string]interface{}{"dns-record-filter-regex": `\.example\.com$`, "cloudflare-update-batch-size": 2})
+
+	if err := updateDNS(context.Background(), ""); err != nil {
+		t.Fatalf("updateDNS() error = %v", err)
+	}
+	if len(cf.updates) != 2 {
+		t.Errorf("updates = %+v, want both records", cf.updates)
+	}
+}
+
+func TestUpdateDNSSkipPrefetch(t *testing.T) {
+	setCredentials(t, "test-token", "example.com", "home.example.com")
+	newIPService(t, "203.0.113.7")
+	cf := newMockCloudflare(t, "example.com",
+		cloudflare.DNSRecord{ID: "record-id", Type: "A", Name: "home.example.com", Content: "198.51.100.1", TTL: 300, Proxied: boolPtr(true)})
+	setConfig(t, map[string]interface{}{"skip-prefetch": true, "record-id": "record-id"})
+
+	if err := updateDNS(context.Background(), ""); err != nil {
+		t.Fatalf("updateDNS() error = %v", err)
+	}
+	if cf.lists != 0 {
+		t.Errorf("listed DNS records %d times, want 0", cf.lists)
+	}
+	if len(cf.updates) != 1 || cf.updates[0].Content != "203.0.113.7" {
+		t.Errorf("updates = %+v, want one pointing at 203.0.113.7", cf.updates)
+	}
+	// Without --ttl and --proxied the record keeps its TTL and proxy setting
+	if r := cf.records[0]; r.TTL != 300 || r.Proxied == nil || !*r.Proxied {
+		t.Errorf("record TTL = %d, proxied = %v, want 300 and proxied", r.TTL, r.Proxied)
+	}
+}
+
+// TestRunDaemonRefreshFailure checks that a failed credential refresh keeps the
+// previous credentials and counts toward --max-errors instead of exiting
+func TestRunDaemonRefreshFailure(t *testing.T) {
+	setCredentials(t, "test-token", "example.com", "home.example.com")
+	newIPService(t, "203.0.113.7")
+	newMockCloudflare(t, "example.com",
+		cloudflare.DNSRecord{ID: "record-id", Type: "A", Name: "home.example.com", Content: "203.0.113.7"})
+	// The flags backend without --api-token has nothing to refresh from
+	setConfig(t, map[string]interface{}{
+		"secrets-backend":              "flags",
+		"api-token":                    "",
+		"refresh-credentials-interval": time.Nanosecond,
+		"max-errors":                   1,
+	})
+
+	if err := runDaemon(context.Background(), 0, nil, nil); err == nil {
+		t.Fatal("runDaemon() succeeded, want it to give up after the failed refresh")
+	}
+	if token := globalAPIToken(); token != "test-token" {
+		t.Errorf("API token = %q after a failed refresh, want the previous one", token)
+	}
+}
+
+// TestUpdateDNSGeoRestriction checks that an IP outside --cloudflare-geo-restriction
+// skips the update without failing the cycle
+func TestUpdateDNSGeoRestriction(t *testing.T) {
+	setCredentials(t, "test-token", "example.com", "home.example.com")
+	newIPService(t, "203.0.113.7")
+	cf := newMockCloudflare(t, "example.com",
+		cloudflare.DNSRecord{ID: "record-id", Type: "A", Name: "home.example.com", Content: "198.51.100.1"})
+	geo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "NL")
+	}))
+	t.Cleanup(geo.Close)
+	setConfig(t, map[string]interface{}{"cloudflare-geo-restriction": "DE", "geo-api-url": geo.URL + "/{ip}"})
+
+	if err := updateDNS(context.Background(), ""); err != nil {
+		t.Fatalf("updateDNS() error = %v, want the update skipped", err)
+	}
+	if len(cf.updates) != 0 {
+		t.Errorf("updates = %+v, want none for an IP outside DE", cf.updates)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/spf13/viper"
+)
+
+// validatorRequest is posted to --record-content-validator before a record changes
+type validatorRequest struct {
+	IP     string `json:"ip"`
+	Record string `json:"record"`
+	Zone   string `json:"zone"`
+}
+
+// validatorResponse is the policy decision of --record-content-validator
+type validatorResponse struct {
+	Allow bool `json:"allow"`
+}
+
+// checkRecordContent asks --record-content-validator, if set, whether record may
+// point at ip. Anything but HTTP 200 with {"allow":true} blocks the change.
+func checkRecordContent(ctx context.Context, record, ip string) error {
+	url := viper.GetString("record-content-validator")
+	if url == "" {
+		return nil
+	}
+
+	zoneName, _ := currentZone(ctx)
+	body, err := json.Marshal(validatorRequest{IP: ip, Record: record, Zone: zoneName})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error calling record content validator: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("record content validator rejected %s -> %s with HTTP %d", record, ip, resp.StatusCode)
+	}
+	var decision validatorResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&decision); err != nil {
+		return fmt.Errorf("unable to parse record content validator response: %w", err)
+	}
+	if !decision.Allow {
+		return fmt.Errorf("record content validator rejected %s -> %s", record, ip)
+	}
+	return nil
+}
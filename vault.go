@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/spf13/viper"
+)
+
+// newVaultClient creates a Vault client for VAULT_ADDR, or connecting through the
+// Vault Agent socket when --vault-agent-socket is set. It is not logged in yet, so
+// the unauthenticated seal status can be checked first; see authenticateVault.
+func newVaultClient() (*api.Client, error) {
+	config := api.DefaultConfig()
+	if config.Error != nil {
+		return nil, config.Error
+	}
+
+	// Validate the certificate against a different name than the address, e.g.
+	// when Vault sits behind a load balancer
+	if serverName := viper.GetString("vault-tls-server-name"); serverName != "" {
+		if err := config.ConfigureTLS(&api.TLSConfig{TLSServerName: serverName}); err != nil {
+			return nil, fmt.Errorf("error configuring Vault TLS: %w", err)
+		}
+	}
+
+	socketPath := viper.GetString("vault-agent-socket")
+	if socketPath != "" {
+		// The host is ignored once the transport dials the socket directly
+		config.Address = "http://vault-agent"
+		config.HttpClient.Transport.(*http.Transport).DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socketPath)
+		}
+	}
+
+	// Create a new Vault client
+	client, err := api.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if socketPath != "" {
+		// Vault Agent injects its auto-auth token into proxied requests
+		client.ClearToken()
+	}
+	return client, nil
+}
+
+// authenticateVault logs client in with --vault-auth-method. Through the Vault
+// Agent socket no login is needed.
+func authenticateVault(ctx context.Context, client *api.Client) error {
+	if viper.GetString("vault-agent-socket") != "" {
+		return nil
+	}
+	if err := vaultLogin(ctx, client); err != nil {
+		return fmt.Errorf("unable to authenticate with Vault: %w", err)
+	}
+	return nil
+}
+
+// vaultSecretPath builds the logical read path from --vault-kv-mount and
+// --vault-secret-path
+func vaultSecretPath() (string, error) {
+	return vaultKVPath(viper.GetString("vault-secret-path"))
+}
+
+// vaultKVPath builds the logical read path of secretPath in --vault-kv-mount.
+// KV v2 reads go through the mount's data/ prefix.
+func vaultKVPath(secretPath string) (string, error) {
+	mount := strings.Trim(viper.GetString("vault-kv-mount"), "/")
+	secretPath = strings.Trim(secretPath, "/")
+	if mount == "" || secretPath == "" {
+		return "", fmt.Errorf("--vault-kv-mount and the secret path must not be empty")
+	}
+
+	switch version := viper.GetInt("vault-kv-version"); version {
+	case 1:
+		return path.Join(mount, secretPath), nil
+	case 2:
+		return path.Join(mount, "data", secretPath), nil
+	default:
+		return "", fmt.Errorf("unsupported --vault-kv-version %d (want 1 or 2)", version)
+	}
+}
+
+// checkVaultUnsealed returns an error if Vault is sealed, checking up to retries
+// more times with exponential backoff before giving up
+func checkVaultUnsealed(ctx context.Context, client *api.Client, retries int) error {
+	delay := time.Second
+	for attempt := 0; ; attempt++ {
+		status, err := client.Sys().SealStatusWithContext(ctx)
+		switch {
+		case err != nil:
+			err = fmt.Errorf("error reading Vault seal status: %w", err)
+		case status.Sealed:
+			err = fmt.Errorf("vault is sealed (%d of %d unseal keys provided)", status.Progress, status.T)
+		default:
+			return nil
+		}
+
+		if attempt >= retries {
+			return err
+		}
+		slog.Warn("Vault seal status check failed, retrying", "error", err, "delay", delay)
+		if err := sleepContext(ctx, delay); err != nil {
+			return err
+		}
+		delay = min(delay*2, 30*time.Second)
+	}
+}
+
+// transitDecrypt decrypts a "vault:v<N>:..." ciphertext with the named transit key
+func transitDecrypt(client *api.Client, key, ciphertext string) (string, error) {
+	mount := strings.Trim(viper.GetString("vault-transit-mount"), "/")
+	secret, err := client.Logical().Write(path.Join(mount, "decrypt", key), map[string]interface{}{
+		"ciphertext": ciphertext,
+	})
+	if err != nil {
+		return "", fmt.Errorf("error decrypting with transit key %s: %w", key, err)
+	}
+	if secret == nil {
+		return "", fmt.Errorf("empty response decrypting with transit key %s", key)
+	}
+
+	encoded, ok := secret.Data["plaintext"].(string)
+	if !ok {
+		return "", fmt.Errorf("transit decrypt response for key %s has no plaintext", key)
+	}
+	plaintext, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("error decoding transit plaintext: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// secretLease tracks the lease of the last secret read from Vault
+var secretLease struct {
+	sync.Mutex
+	duration time.Duration
+	expires  time.Time
+}
+
+func setSecretLease(ttlSeconds int) {
+	secretLease.Lock()
+	defer secretLease.Unlock()
+	secretLease.duration = time.Duration(ttlSeconds) * time.Second
+	secretLease.expires = time.Now().Add(secretLease.duration)
+}
+
+// checkLeaseDuration warns when the secret's lease expires before the update due
+// after interval, and notes when --refresh-credentials-interval keeps it alive
+func checkLeaseDuration(interval time.Duration) {
+	secretLease.Lock()
+	duration, expires := secretLease.duration, secretLease.expires
+	secretLease.Unlock()
+	if duration <= 0 {
+		return
+	}
+
+	if next := time.Now().Add(interval); expires.Before(next) {
+		slog.Warn("Vault secret lease expires before the next update", "expires_at", expires.Format(time.RFC3339), "next_update", next.Format(time.RFC3339))
+	}
+	if refresh := viper.GetDuration("refresh-credentials-interval"); refresh > 0 && refresh < duration {
+		slog.Info("Credential refresh will keep the Vault lease alive", "refresh_interval", refresh, "lease_duration", duration)
+	}
+}
+
+// stopLeaseRenewal stops renewing the lease of the previously read secret, if any
+var stopLeaseRenewal = func() {}
+
+// startLeaseRenewal keeps a dynamic secret's lease alive by renewing it at half its
+// TTL. A later call replaces the renewal of the previous secret.
+func startLeaseRenewal(client *api.Client, secret *api.Secret) {
+	stopLeaseRenewal()
+	setSecretLease(secret.LeaseDuration)
+	if secret.LeaseID == "" || !secret.Renewable || secret.LeaseDuration <= 0 {
+		stopLeaseRenewal = func() {}
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopLeaseRenewal = cancel
+
+	go func() {
+		leaseID, ttl := secret.LeaseID, secret.LeaseDuration
+		for {
+			select {
+			case <-time.After(time.Duration(ttl) * time.Second / 2):
+			case <-ctx.Done():
+				return
+			}
+
+			renewed, err := client.Sys().RenewWithContext(ctx, leaseID, secret.LeaseDuration)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				slog.Error("Error renewing Vault lease", "lease_id", leaseID, "error", err)
+				// Try again before the current lease runs out
+				ttl /= 2
+				if ttl < 2 {
+					return
+				}
+				continue
+			}
+			ttl = renewed.LeaseDuration
+			setSecretLease(ttl)
+			slog.Info("Renewed Vault lease", "lease_id", leaseID, "ttl_seconds", ttl)
+			if ttl <= 0 {
+				return
+			}
+		}
+	}()
+}
+
+// retrieveVaultSecret returns the API token, record names and zone name stored
+// in Vault. When Vault cannot be read it falls back to --credential-cache-file,
+// failing if there are no usable cached credentials either.
+func retrieveVaultSecret(ctx context.Context) (string, string, string, error) {
+	apiToken, recordName, zoneName, err := readVaultSecret(ctx)
+	if err != nil {
+		if viper.GetString("credential-cache-file") == "" {
+			return "", "", "", fmt.Errorf("unable to read credentials from Vault: %w", err)
+		}
+		creds, cacheErr := loadCredentialCache()
+		if cacheErr != nil {
+			return "", "", "", fmt.Errorf("unable to read credentials from Vault (%w) or the credential cache: %w", err, cacheErr)
+		}
+		slog.Warn("Vault is unavailable, using cached credentials", "error", err, "cached_at", creds.CachedAt.Format(time.RFC3339))
+		return creds.APIToken, creds.RecordName, creds.ZoneName, nil
+	}
+
+	if err := saveCredentialCache(cachedCredentials{
+		APIToken:   apiToken,
+		RecordName: recordName,
+		ZoneName:   zoneName,
+		CachedAt:   time.Now().UTC(),
+	}); err != nil {
+		slog.Warn("Unable to update the credential cache", "error", err)
+	}
+	return apiToken, recordName, zoneName, nil
+}
+
+// readVaultSecret reads the API token, record names and zone name from the
+// configured Vault KV secret
+func readVaultSecret(ctx context.Context) (apiToken, recordName, zoneName string, err error) {
+	client, err := newVaultClient()
+	if err != nil {
+		return "", "", "", fmt.Errorf("unable to initialize Vault client: %w", err)
+	}
+
+	if viper.GetBool("vault-seal-status-check") {
+		if err := checkVaultUnsealed(ctx, client, viper.GetInt("vault-seal-retry")); err != nil {
+			return "", "", "", fmt.Errorf("vault is not available: %w", err)
+		}
+	}
+	if err := authenticateVault(ctx, client); err != nil {
+		return "", "", "", err
+	}
+
+	// Read the secret from the configured KV mount and path
+	secretPath, err := vaultSecretPath()
+	if err != nil {
+		return "", "", "", fmt.Errorf("invalid Vault secret path: %w", err)
+	}
+	secret, secretData, err := readKVData(client, secretPath)
+	if err != nil {
+		return "", "", "", err
+	}
+	startLeaseRenewal(client, secret)
+
+	apiToken, err = secretAPIToken(client, secretData, secretPath)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	// Extract the record-name value
+	recordKey := viper.GetString("vault-record-name-key")
+	recordName, ok := secretData[recordKey].(string)
+	if !ok {
+		return "", "", "", fmt.Errorf("%s not found or is not a string in the secret at %s", recordKey, secretPath)
+	}
+
+	// Extract the zone-name value
+	zoneKey := viper.GetString("vault-zone-name-key")
+	zoneName, ok = secretData[zoneKey].(string)
+	if !ok {
+		return "", "", "", fmt.Errorf("%s not found or is not a string in the secret at %s", zoneKey, secretPath)
+	}
+
+	return apiToken, recordName, zoneName, nil
+}
+
+// readVaultZoneToken reads the API token of one zone from secretPath in
+// --vault-kv-mount, for zones listing a vault-path in --token-per-zone mode
+func readVaultZoneToken(secretPath string) (string, error) {
+	client, err := newVaultClient()
+	if err != nil {
+		return "", fmt.Errorf("unable to initialize Vault client: %w", err)
+	}
+	if err := authenticateVault(context.Background(), client); err != nil {
+		return "", err
+	}
+	logicalPath, err := vaultKVPath(secretPath)
+	if err != nil {
+		return "", fmt.Errorf("invalid Vault secret path: %w", err)
+	}
+	_, secretData, err := readKVData(client, logicalPath)
+	if err != nil {
+		return "", err
+	}
+	return secretAPIToken(client, secretData, logicalPath)
+}
+
+// readKVData reads the secret at the logical secretPath and returns its data
+func readKVData(client *api.Client, secretPath string) (*api.Secret, map[string]interface{}, error) {
+	secret, err := client.Logical().Read(secretPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read secret %s: %w", secretPath, err)
+	}
+	if secret == nil {
+		return nil, nil, fmt.Errorf("no secret found at %s", secretPath)
+	}
+
+	secretData := secret.Data
+	if viper.GetInt("vault-kv-version") == 2 {
+		data, ok := secret.Data["data"].(map[string]interface{})
+		if !ok {
+			return nil, nil, fmt.Errorf("failed to parse secret data at %s", secretPath)
+		}
+		secretData = data
+	}
+
+	slog.Debug("Retrieved secret from Vault", "path", secretPath)
+	return secret, secretData, nil
+}
+
+// secretAPIToken extracts the --vault-api-token-key value of a secret read from
+// secretPath, decrypting it when --decrypt-vault-transit-key is set
+func secretAPIToken(client *api.Client, secretData map[string]interface{}, secretPath string) (string, error) {
+	tokenKey := viper.GetString("vault-api-token-key")
+	apiToken, ok := secretData[tokenKey].(string)
+	if !ok {
+		return "", fmt.Errorf("%s not found or is not a string in the secret at %s", tokenKey, secretPath)
+	}
+
+	// The stored api-token may itself be transit ciphertext
+	if key := viper.GetString("decrypt-vault-transit-key"); key != "" {
+		decrypted, err := transitDecrypt(client, key, apiToken)
+		if err != nil {
+			return "", fmt.Errorf("unable to decrypt api-token: %w", err)
+		}
+		apiToken = decrypted
+	}
+	return apiToken, nil
+}
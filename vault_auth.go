@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/spf13/viper"
+)
+
+// defaultKubernetesTokenPath is where Kubernetes mounts the pod's service account token
+const defaultKubernetesTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+func validateVaultAuthMethod(method string) error {
+	switch method {
+	case "token", "approle", "kubernetes":
+		return nil
+	}
+	return fmt.Errorf("unsupported Vault auth method %q (want token, approle or kubernetes)", method)
+}
+
+// vaultLogin authenticates client with --vault-auth-method. Token auth uses
+// VAULT_TOKEN, which the Vault SDK has already read from the environment.
+func vaultLogin(ctx context.Context, client *api.Client) error {
+	method := viper.GetString("vault-auth-method")
+	if err := validateVaultAuthMethod(method); err != nil {
+		return err
+	}
+
+	if method == "token" && client.Token() == "" {
+		// Inside a pod with a Vault role configured, the service account token
+		// stands in for a static VAULT_TOKEN
+		if !inKubernetesPod() || viper.GetString("vault-kubernetes-role") == "" {
+			return fmt.Errorf("VAULT_TOKEN is not set")
+		}
+		method = "kubernetes"
+	}
+	if method == "token" {
+		return nil
+	}
+
+	mount := strings.Trim(viper.GetString("vault-auth-mount"), "/")
+	if mount == "" {
+		mount = method
+	}
+
+	var auth api.AuthMethod
+	switch method {
+	case "approle":
+		roleID, secretID := os.Getenv("VAULT_ROLE_ID"), os.Getenv("VAULT_SECRET_ID")
+		if roleID == "" || secretID == "" {
+			return fmt.Errorf("VAULT_ROLE_ID and VAULT_SECRET_ID must be set for AppRole auth")
+		}
+		auth = loginAuth{path: path.Join("auth", mount, "login"), data: map[string]interface{}{
+			"role_id":   roleID,
+			"secret_id": secretID,
+		}}
+	case "kubernetes":
+		role := viper.GetString("vault-kubernetes-role")
+		if role == "" {
+			return fmt.Errorf("--vault-kubernetes-role must be set for Kubernetes auth")
+		}
+		jwt, err := os.ReadFile(viper.GetString("vault-kubernetes-token-path"))
+		if err != nil {
+			return fmt.Errorf("unable to read service account token: %w", err)
+		}
+		auth = loginAuth{path: path.Join("auth", mount, "login"), data: map[string]interface{}{
+			"role": role,
+			"jwt":  strings.TrimSpace(string(jwt)),
+		}}
+	}
+
+	client.ClearToken()
+	if _, err := client.Auth().Login(ctx, auth); err != nil {
+		return fmt.Errorf("error logging in to Vault with %s auth: %w", method, err)
+	}
+	return nil
+}
+
+// inKubernetesPod reports whether the process runs in a Kubernetes pod with a
+// mounted service account token
+func inKubernetesPod() bool {
+	if os.Getenv("KUBERNETES_SERVICE_HOST") == "" {
+		return false
+	}
+	_, err := os.Stat(viper.GetString("vault-kubernetes-token-path"))
+	return err == nil
+}
+
+// loginAuth is an api.AuthMethod that writes data to a login endpoint
+type loginAuth struct {
+	path string
+	data map[string]interface{}
+}
+
+func (a loginAuth) Login(ctx context.Context, client *api.Client) (*api.Secret, error) {
+	return client.Logical().WriteWithContext(ctx, a.path, a.data)
+}
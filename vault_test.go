@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newMockVault serves a KV v2 secret at secret/data/cloudflare, or fails every
+// request with status when it is not 200
+func newMockVault(t *testing.T, status int, data map[string]interface{}) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "vault-token" {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		if status != http.StatusOK {
+			http.Error(w, `{"errors":["Vault is sealed"]}`, status)
+			return
+		}
+		if r.Method != http.MethodGet || r.URL.Path != "/v1/secret/data/cloudflare" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{"data": data},
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func TestReadVaultSecret(t *testing.T) {
+	secret := map[string]interface{}{
+		"api-token":   "cf-token",
+		"record-name": "home.example.com,vpn.example.com",
+		"zone-name":   "example.com",
+	}
+
+	tests := []struct {
+		name    string
+		addr    func(t *testing.T) string
+		keys    map[string]interface{}
+		wantErr bool
+	}{
+		{
+			name: "secret is read",
+			addr: func(t *testing.T) string { return newMockVault(t, http.StatusOK, secret) },
+		},
+		{
+			name: "secret is missing a field",
+			addr: func(t *testing.T) string {
+				return newMockVault(t, http.StatusOK, map[string]interface{}{"api-token": "cf-token"})
+			},
+			wantErr: true,
+		},
+		{
+			name:    "Vault is sealed",
+			addr:    func(t *testing.T) string { return newMockVault(t, http.StatusServiceUnavailable, nil) },
+			wantErr: true,
+		},
+		{
+			name: "Vault is unreachable",
+			addr: func(t *testing.T) string {
+				srv := httptest.NewServer(http.NotFoundHandler())
+				srv.Close()
+				return srv.URL
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("VAULT_ADDR", tt.addr(t))
+			t.Setenv("VAULT_TOKEN", "vault-token")
+			t.Setenv("VAULT_MAX_RETRIES", "0")
+			setConfig(t, tt.keys)
+			t.Cleanup(func() { stopLeaseRenewal() })
+
+			token, names, zone, err := readVaultSecret(context.Background())
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("readVaultSecret() succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readVaultSecret() error = %v", err)
+			}
+			if token != "cf-token" || names != "home.example.com,vpn.example.com" || zone != "example.com" {
+				t.Errorf("readVaultSecret() = %q, %q, %q", token, names, zone)
+			}
+		})
+	}
+}
+
+func TestRetrieveVaultSecretFallsBackToCache(t *testing.T) {
+	setConfig(t, map[string]interface{}{
+		"credential-cache-file": filepath.Join(t.TempDir(), "credentials"),
+		"credential-cache-key":  "cache-key",
+	})
+	t.Setenv("VAULT_TOKEN", "vault-token")
+	t.Setenv("VAULT_MAX_RETRIES", "0")
+	t.Cleanup(func() { stopLeaseRenewal() })
+
+	// A successful read refreshes the cache
+	t.Setenv("VAULT_ADDR", newMockVault(t, http.StatusOK, map[string]interface{}{
+		"api-token":   "cf-token",
+		"record-name": "home.example.com",
+		"zone-name":   "example.com",
+	}))
+	if _, _, _, err := retrieveVaultSecret(context.Background()); err != nil {
+		t.Fatalf("retrieveVaultSecret() error = %v", err)
+	}
+
+	// which is used once Vault is sealed
+	t.Setenv("VAULT_ADDR", newMockVault(t, http.StatusServiceUnavailable, nil))
+	token, names, zone, err := retrieveVaultSecret(context.Background())
+	if err != nil {
+		t.Fatalf("retrieveVaultSecret() error = %v", err)
+	}
+	if token != "cf-token" || names != "home.example.com" || zone != "example.com" {
+		t.Errorf("retrieveVaultSecret() = %q, %q, %q, want the cached credentials", token, names, zone)
+	}
+
+	// but not once it has expired
+	setConfig(t, map[string]interface{}{"credential-cache-ttl": time.Nanosecond})
+	if _, err := loadCredentialCache(); err == nil {
+		t.Error("loadCredentialCache() accepted credentials older than --credential-cache-ttl")
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime/debug"
+	"strings"
+	"text/tabwriter"
+)
+
+// BuildVersion is the release version, set at build time with
+// -ldflags "-X main.BuildVersion=v1.2.3"
+var BuildVersion = ""
+
+// version returns BuildVersion, falling back to the module version recorded by the Go toolchain
+func version() string {
+	if BuildVersion != "" {
+		return BuildVersion
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "unknown"
+}
+
+// printBuildInfo prints the version, toolchain, module and VCS details of the binary
+func printBuildInfo(w io.Writer) error {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return fmt.Errorf("build information is not available in this binary")
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "version\t%s\n", version())
+	fmt.Fprintf(tw, "go\t%s\n", info.GoVersion)
+	fmt.Fprintf(tw, "module\t%s\n", info.Main.Path)
+	fmt.Fprintf(tw, "module version\t%s\n", info.Main.Version)
+
+	var tags string
+	for _, setting := range info.Settings {
+		switch {
+		case setting.Key == "-tags":
+			tags = setting.Value
+		case strings.HasPrefix(setting.Key, "vcs"):
+			fmt.Fprintf(tw, "%s\t%s\n", setting.Key, setting.Value)
+		}
+	}
+	fmt.Fprintf(tw, "build tags\t%s\n", tags)
+
+	return tw.Flush()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// webhookTimeout bounds notifications sent outside an update's own context
+const webhookTimeout = 10 * time.Second
+
+// notifyIPChanged posts an ip_changed event for record to --webhook-url
+func notifyIPChanged(ctx context.Context, record, oldIP, newIP string) {
+	sendWebhook(ctx, map[string]string{
+		"event":  "ip_changed",
+		"old_ip": oldIP,
+		"new_ip": newIP,
+		"record": record,
+	})
+}
+
+// notifyUpdateFailed posts an update_failed event for the records of ctx's zone
+// to --webhook-url
+func notifyUpdateFailed(ctx context.Context, err error) {
+	_, names := currentZone(ctx)
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+	sendWebhook(ctx, map[string]string{
+		"event":  "update_failed",
+		"error":  err.Error(),
+		"record": strings.Join(names, ","),
+	})
+}
+
+// sendWebhook posts event, stamped with the current time, to --webhook-url. A
+// failed notification is logged and otherwise ignored.
+func sendWebhook(ctx context.Context, event map[string]string) {
+	url := viper.GetString("webhook-url")
+	if url == "" {
+		return
+	}
+	event["timestamp"] = time.Now().UTC().Format(time.RFC3339)
+
+	if err := postWebhook(ctx, url, event); err != nil {
+		slog.Warn("Unable to send webhook", "event", event["event"], "error", err)
+	}
+}
+
+func postWebhook(ctx context.Context, url string, event map[string]string) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// splitRecordNames splits comma-separated record names and drops empty entries
+func splitRecordNames(values ...string) []string {
+	var names []string
+	for _, value := range values {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// forEachRecordName runs update for every configured record name on a pool of
+// --record-workers goroutines and returns all failures joined. The filter of
+// --dns-record-filter-regex selects records regardless of name, so update then
+// runs only once.
+func forEachRecordName(ctx context.Context, update func(name string) error) error {
+	_, names := currentZone(ctx)
+	if viper.GetString("dns-record-filter-regex") != "" && len(names) > 1 {
+		names = names[:1]
+	}
+	workers := min(max(viper.GetInt("record-workers"), 1), len(names))
+
+	jobs := make(chan int)
+	errs := make([]error, len(names))
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				errs[i] = update(names[i])
+			}
+		}()
+	}
+	for i := range names {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
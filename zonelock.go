@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/spf13/viper"
+)
+
+// Workers KV rejects expirations shorter than a minute
+const minZoneLockTTL = 60 * time.Second
+
+// zoneLockSettle is how long to wait after writing the lock before reading it
+// back, giving a competing writer's value time to win instead
+const zoneLockSettle = 2 * time.Second
+
+// zoneLock is a lease on --cloudflare-zone-lock-file in a Workers KV namespace,
+// shared by every process updating records in the same zone
+type zoneLock struct {
+	api         *cloudflare.API
+	account     *cloudflare.ResourceContainer
+	namespaceID string
+	key         string
+	token       string
+
+	// stopRenewal ends renew, which closes renewed once it has returned
+	stopRenewal context.CancelFunc
+	renewed     chan struct{}
+}
+
+// acquireZoneLock takes the zone lock, retrying with backoff while another process
+// holds it. KV has no compare-and-swap, so the lock is optimistic: the lease token
+// is written and read back, and whoever's token survives holds the lock. A holder
+// that dies releases it when the entry expires after --cloudflare-zone-lock-ttl.
+func acquireZoneLock(ctx context.Context, api *cloudflare.API) (*zoneLock, error) {
+	ttl := viper.GetDuration("cloudflare-zone-lock-ttl")
+	if ttl < minZoneLockTTL {
+		return nil, fmt.Errorf("--cloudflare-zone-lock-ttl %s must be at least %s", ttl, minZoneLockTTL)
+	}
+	namespaceID := viper.GetString("cloudflare-zone-lock-namespace-id")
+	if namespaceID == "" {
+		return nil, fmt.Errorf("--cloudflare-zone-lock-namespace-id is required with --cloudflare-zone-lock-file")
+	}
+
+	accountID, err := resolveAccountID(ctx, api)
+	if err != nil {
+		return nil, err
+	}
+	account, err := resolveResourceContainer(ctx, api, "", accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	lock := &zoneLock{
+		api:         api,
+		account:     account,
+		namespaceID: namespaceID,
+		key:         viper.GetString("cloudflare-zone-lock-file"),
+		token:       newRequestID(),
+	}
+
+	// Any holder's lease has run out by the time a full TTL has passed
+	ctx, cancel := context.WithTimeout(ctx, 2*ttl)
+	defer cancel()
+
+	delay := time.Second
+	for {
+		holder, err := lock.holder(ctx)
+		if err == nil && holder == "" {
+			if err = lock.write(ctx, ttl); err == nil {
+				err = sleepContext(ctx, zoneLockSettle)
+			}
+			if err == nil {
+				holder, err = lock.holder(ctx)
+			}
+			if err == nil && holder == lock.token {
+				slog.Debug("Acquired zone lock", "key", lock.key)
+				renewCtx, stop := context.WithCancel(context.Background())
+				lock.stopRenewal, lock.renewed = stop, make(chan struct{})
+				go lock.renew(renewCtx, ttl)
+				return lock, nil
+			}
+		}
+
+		if err != nil {
+			slog.Warn("Error checking zone lock, retrying", "key", lock.key, "error", err, "delay", delay)
+		} else {
+			slog.Info("Zone lock is held by another process, retrying", "key", lock.key, "holder", holder, "delay", delay)
+		}
+		if err := sleepContext(ctx, delay); err != nil {
+			return nil, fmt.Errorf("timed out waiting for zone lock %s: %w", lock.key, err)
+		}
+		delay = min(delay*2, 30*time.Second)
+	}
+}
+
+// holder returns the lease token stored in the lock, or "" when it is free
+func (l *zoneLock) holder(ctx context.Context) (string, error) {
+	value, err := l.api.GetWorkersKV(ctx, l.account, cloudflare.GetWorkersKVParams{
+		NamespaceID: l.namespaceID,
+		Key:         l.key,
+	})
+	var notFound *cloudflare.NotFoundError
+	if errors.As(err, &notFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error reading zone lock: %w", err)
+	}
+	return strings.TrimSpace(string(value)), nil
+}
+
+// write stores the lease token with an expiration of ttl. Only the bulk write
+// endpoint accepts an expiration.
+func (l *zoneLock) write(ctx context.Context, ttl time.Duration) error {
+	_, err := l.api.WriteWorkersKVEntries(ctx, l.account, cloudflare.WriteWorkersKVEntriesParams{
+		NamespaceID: l.namespaceID,
+		KVs: []*cloudflare.WorkersKVPair{{
+			Key:           l.key,
+			Value:         l.token,
+			ExpirationTTL: int(ttl.Seconds()),
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("error writing zone lock: %w", err)
+	}
+	return nil
+}
+
+// renew extends the lease every third of ttl until ctx is done, so the lock
+// outlasts --cloudflare-zone-lock-ttl when an update takes longer, such as while
+// --cloudflare-record-ttl-before-update waits. It stops once another process
+// holds the lock.
+func (l *zoneLock) renew(ctx context.Context, ttl time.Duration) {
+	defer close(l.renewed)
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		writeCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		holder, err := l.holder(writeCtx)
+		if err == nil && holder != l.token {
+			cancel()
+			slog.Warn("Zone lock was taken over, no longer renewing it", "key", l.key, "holder", holder)
+			return
+		}
+		if err == nil {
+			err = l.write(writeCtx, ttl)
+		}
+		cancel()
+		if err != nil && ctx.Err() == nil {
+			slog.Warn("Error renewing zone lock", "key", l.key, "error", err)
+		}
+	}
+}
+
+// release deletes the lock if this process still holds it
+func (l *zoneLock) release() {
+	l.stopRenewal()
+	<-l.renewed
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	holder, err := l.holder(ctx)
+	if err == nil && holder != l.token {
+		slog.Warn("Zone lock was taken over before release", "key", l.key, "holder", holder)
+		return
+	}
+	if err == nil {
+		_, err = l.api.DeleteWorkersKVEntry(ctx, l.account, cloudflare.DeleteWorkersKVEntryParams{
+			NamespaceID: l.namespaceID,
+			Key:         l.key,
+		})
+	}
+	if err != nil {
+		slog.Error("Error releasing zone lock; it expires on its own", "key", l.key, "error", err)
+		return
+	}
+	slog.Debug("Released zone lock", "key", l.key)
+}
+
+// sleepContext waits for d, returning early with ctx's error when it is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
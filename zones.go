@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// lookupZoneID returns the ID of the named zone, preferring the entry in
+// --zone-id-mapping-file over a ZoneIDByName API call. With --update-mapping-file,
+// IDs fetched from the API are added to the mapping file.
+func lookupZoneID(api *cloudflare.API, name string) (string, error) {
+	path := viper.GetString("zone-id-mapping-file")
+	if path == "" {
+		return api.ZoneIDByName(name)
+	}
+
+	// Zones updated concurrently share the mapping file
+	zoneMappingMu.Lock()
+	defer zoneMappingMu.Unlock()
+
+	mapping, err := readZoneMapping(path)
+	if err != nil {
+		return "", err
+	}
+	if id, ok := mapping[name]; ok {
+		return id, nil
+	}
+
+	id, err := api.ZoneIDByName(name)
+	if err != nil {
+		return "", err
+	}
+
+	if viper.GetBool("update-mapping-file") {
+		mapping[name] = id
+		if err := writeZoneMapping(path, mapping); err != nil {
+			slog.Warn("Unable to update zone ID mapping file", "path", path, "error", err)
+		}
+	}
+	return id, nil
+}
+
+// zoneMappingMu serializes reads and updates of --zone-id-mapping-file
+var zoneMappingMu sync.Mutex
+
+// readZoneMapping reads a YAML map of zone names to zone IDs. A missing file is
+// treated as empty so that --update-mapping-file can create it.
+func readZoneMapping(path string) (map[string]string, error) {
+	mapping := map[string]string{}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return mapping, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(data, &mapping); err != nil {
+		return nil, fmt.Errorf("unable to parse zone ID mapping file %s: %w", path, err)
+	}
+	return mapping, nil
+}
+
+// writeZoneMapping atomically replaces the mapping file
+func writeZoneMapping(path string, mapping map[string]string) error {
+	data, err := yaml.Marshal(mapping)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}